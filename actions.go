@@ -0,0 +1,100 @@
+package leges
+
+import (
+	"context"
+)
+
+// AllowedAction is an action granted to a subject on an object
+type AllowedAction struct {
+	Action string `json:"action"`
+	// PolicyIDs are the ids of the allow policies whose condition holds for
+	// the action
+	PolicyIDs []string `json:"ids"`
+}
+
+// AllowedActions returns every concrete action, among the actions of the
+// policies and the action hierarchy, that Match grants to subject on object,
+// sorted by name. Each condition is evaluated at most once, no matter how many
+// actions the policy has.
+//
+// Actions that can't be decided, for example because a condition fails to
+// evaluate, are refused and left out, without affecting the other actions.
+// The error is only non-nil if the request is invalid, or if the context of
+// AllowedActionsContext is done.
+func (l *Leges) AllowedActions(subject, object Attributes) ([]AllowedAction, error) {
+	return l.AllowedActionsContext(context.Background(), Request{Subject: subject, Object: object})
+}
+
+// AllowedActionsContext is like AllowedActions, but takes the subject, the
+// object and the environment from request, whose Action is ignored, and
+// evaluates the policies with ctx.
+func (l *Leges) AllowedActionsContext(ctx context.Context, request Request) ([]AllowedAction, error) {
+	request.Action = "*"
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	outcomes := make([]*outcome, len(l.cachedPolicies))
+	allowed := []AllowedAction{}
+
+	for _, action := range l.actions() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		request.Action = action
+		normalizedRequest := l.normalizeRequest(ctx, request)
+		c := combiner{algorithm: l.algorithm}
+		decided := false
+		indeterminate := false
+		var policyIDs []string
+
+		requested := l.requestedAction(action)
+
+		for i, statute := range l.cachedPolicies {
+			if !requested.appliesTo(statute.policy) {
+				continue
+			}
+
+			if outcomes[i] == nil {
+				ok, err := l.run(ctx, statute, request, normalizedRequest)
+				outcomes[i] = &outcome{ok: ok, err: err}
+			}
+			if err := outcomes[i].err; err != nil && !decided && !l.skipsErrors(ctx) {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return nil, ctxErr
+				}
+				policy := statute.policy
+				if c.addError(&policy, err) {
+					continue
+				}
+				indeterminate = true
+				break
+			}
+			if !outcomes[i].ok {
+				continue
+			}
+
+			if statute.policy.allows() {
+				policyIDs = append(policyIDs, statute.policy.ID)
+			}
+			if !decided {
+				policy := statute.policy
+				decided = c.add(&policy)
+			}
+		}
+
+		if indeterminate {
+			continue
+		}
+		ok, _, err := c.result()
+		if err == nil && ok {
+			allowed = append(allowed, AllowedAction{
+				Action:    action,
+				PolicyIDs: policyIDs,
+			})
+		}
+	}
+
+	return allowed, nil
+}
@@ -0,0 +1,117 @@
+package leges_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowedActions(t *testing.T) {
+	rules := mustNewLeges(t, []leges.Policy{
+		{
+			ID: "admin_can_update_and_view_pages",
+			Condition: `
+				subject.role == "admin"
+				and object.type in ["page", "adminpage"]
+			`,
+			Actions: []string{"VIEW", "UPDATE", "DELETE"},
+		},
+		{
+			ID:        "owner_can_view_and_share",
+			Condition: `object.owner_id == subject.id`,
+			Actions:   []string{"VIEW", "SHARE"},
+		},
+		{
+			ID:        "nobody_can_delete_locked_pages",
+			Condition: `object.locked == true`,
+			Actions:   []string{"DELETE"},
+			Effect:    leges.EffectDeny,
+		},
+		{
+			ID:        "guest_can_signup",
+			Condition: `subject.role == "guest"`,
+			Actions:   []string{"SIGNUP"},
+		},
+	}, nil)
+
+	t.Run("list allowed actions with granting policies", func(t *testing.T) {
+		actions, err := rules.AllowedActions(
+			leges.Attributes{"id": "user1", "role": "admin"},
+			leges.Attributes{"type": "page", "owner_id": "user1", "locked": true},
+		)
+		require.NoError(t, err)
+		require.Equal(t, []leges.AllowedAction{
+			{Action: "SHARE", PolicyIDs: []string{"owner_can_view_and_share"}},
+			{Action: "UPDATE", PolicyIDs: []string{"admin_can_update_and_view_pages"}},
+			{Action: "VIEW", PolicyIDs: []string{"admin_can_update_and_view_pages", "owner_can_view_and_share"}},
+		}, actions)
+	})
+
+	t.Run("list nothing if no action is allowed", func(t *testing.T) {
+		actions, err := rules.AllowedActions(
+			leges.Attributes{"id": "user2", "role": "editor"},
+			leges.Attributes{"type": "page", "owner_id": "user1"},
+		)
+		require.NoError(t, err)
+		require.Empty(t, actions)
+	})
+
+	t.Run("leave out the actions that fail to evaluate", func(t *testing.T) {
+		rules := mustNewLeges(t, []leges.Policy{
+			{
+				ID:        "anyone_can_view",
+				Condition: `true`,
+				Actions:   []string{"VIEW"},
+			},
+			{
+				ID:        "adults_can_buy",
+				Condition: `subject.profile.age >= 18`,
+				Actions:   []string{"BUY"},
+			},
+		}, nil)
+
+		actions, err := rules.AllowedActions(leges.Attributes{"id": "user1"}, leges.Attributes{"type": "page"})
+		require.NoError(t, err)
+		require.Equal(t, []leges.AllowedAction{
+			{Action: "VIEW", PolicyIDs: []string{"anyone_can_view"}},
+		}, actions)
+	})
+
+	t.Run("error if context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := rules.AllowedActionsContext(ctx, leges.Request{
+			Subject: leges.Attributes{"id": "user1"},
+			Object:  leges.Attributes{"type": "page"},
+		})
+		require.Equal(t, context.Canceled, err)
+	})
+
+	t.Run("use the environment of the request", func(t *testing.T) {
+		rules := mustNewLeges(t, []leges.Policy{
+			{
+				ID:        "mfa_can_view",
+				Condition: `env.mfa == true`,
+				Actions:   []string{"VIEW"},
+			},
+		}, nil)
+
+		actions, err := rules.AllowedActionsContext(context.Background(), leges.Request{
+			Subject:     leges.Attributes{"id": "user1"},
+			Object:      leges.Attributes{"type": "page"},
+			Environment: leges.Attributes{"mfa": true},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []leges.AllowedAction{
+			{Action: "VIEW", PolicyIDs: []string{"mfa_can_view"}},
+		}, actions)
+	})
+
+	t.Run("error if subject is empty", func(t *testing.T) {
+		_, err := rules.AllowedActions(nil, leges.Attributes{"type": "page"})
+		require.Equal(t, leges.ErrEmptySubjectAttrs, err)
+	})
+}
@@ -0,0 +1,189 @@
+package leges
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/antonmedv/expr/ast"
+)
+
+// formatNode formats a node of a parsed condition back to an expression.
+// Nested binary and conditional expressions are wrapped in parentheses.
+func formatNode(node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.NilNode:
+		return "nil"
+	case *ast.IdentifierNode:
+		return n.Value
+	case *ast.IntegerNode:
+		return strconv.Itoa(n.Value)
+	case *ast.FloatNode:
+		return strconv.FormatFloat(n.Value, 'g', -1, 64)
+	case *ast.BoolNode:
+		return strconv.FormatBool(n.Value)
+	case *ast.StringNode:
+		return strconv.Quote(n.Value)
+	case *ast.ConstantNode:
+		return fmt.Sprintf("%#v", n.Value)
+	case *ast.UnaryNode:
+		if n.Operator == "not" {
+			return "not " + formatOperand(n.Node)
+		}
+		return n.Operator + formatOperand(n.Node)
+	case *ast.BinaryNode:
+		return formatOperand(n.Left) + " " + n.Operator + " " + formatOperand(n.Right)
+	case *ast.MatchesNode:
+		return formatOperand(n.Left) + " matches " + formatOperand(n.Right)
+	case *ast.PropertyNode:
+		return formatNode(n.Node) + "." + n.Property
+	case *ast.IndexNode:
+		return formatNode(n.Node) + "[" + formatNode(n.Index) + "]"
+	case *ast.SliceNode:
+		var from, to string
+		if n.From != nil {
+			from = formatNode(n.From)
+		}
+		if n.To != nil {
+			to = formatNode(n.To)
+		}
+		return formatNode(n.Node) + "[" + from + ":" + to + "]"
+	case *ast.MethodNode:
+		return formatNode(n.Node) + "." + n.Method + "(" + formatNodes(n.Arguments) + ")"
+	case *ast.FunctionNode:
+		return n.Name + "(" + formatNodes(n.Arguments) + ")"
+	case *ast.BuiltinNode:
+		return n.Name + "(" + formatNodes(n.Arguments) + ")"
+	case *ast.ClosureNode:
+		return "{" + formatNode(n.Node) + "}"
+	case *ast.PointerNode:
+		return "#"
+	case *ast.ConditionalNode:
+		return formatOperand(n.Cond) + " ? " + formatOperand(n.Exp1) + " : " + formatOperand(n.Exp2)
+	case *ast.ArrayNode:
+		return "[" + formatNodes(n.Nodes) + "]"
+	case *ast.MapNode:
+		return "{" + formatNodes(n.Pairs) + "}"
+	case *ast.PairNode:
+		return formatNode(n.Key) + ": " + formatNode(n.Value)
+	}
+	return fmt.Sprintf("%T", node)
+}
+
+func formatOperand(node ast.Node) string {
+	switch node.(type) {
+	case *ast.BinaryNode, *ast.MatchesNode, *ast.ConditionalNode:
+		return "(" + formatNode(node) + ")"
+	}
+	return formatNode(node)
+}
+
+func formatNodes(nodes []ast.Node) string {
+	formatted := make([]string, len(nodes))
+	for i, node := range nodes {
+		formatted[i] = formatNode(node)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// attributePath returns the path of an attribute reference such as
+// subject.role, i.e. a chain of properties on an identifier.
+func attributePath(node ast.Node) ([]string, bool) {
+	switch n := node.(type) {
+	case *ast.IdentifierNode:
+		return []string{n.Value}, true
+	case *ast.PropertyNode:
+		path, ok := attributePath(n.Node)
+		if !ok {
+			return nil, false
+		}
+		return append(path, n.Property), true
+	}
+	return nil, false
+}
+
+// attributePaths returns the outermost attribute references of a node, in
+// the order they appear.
+func attributePaths(node ast.Node) [][]string {
+	if path, ok := attributePath(node); ok {
+		return [][]string{path}
+	}
+
+	var paths [][]string
+	for _, child := range childNodes(node) {
+		paths = append(paths, attributePaths(child)...)
+	}
+	return paths
+}
+
+// refersTo reports whether a node refers to the identifier name
+func refersTo(node ast.Node, name string) bool {
+	if identifier, ok := node.(*ast.IdentifierNode); ok {
+		return identifier.Value == name
+	}
+
+	for _, child := range childNodes(node) {
+		if refersTo(child, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// childNodes returns the direct children of a node
+func childNodes(node ast.Node) []ast.Node {
+	switch n := node.(type) {
+	case *ast.UnaryNode:
+		return []ast.Node{n.Node}
+	case *ast.BinaryNode:
+		return []ast.Node{n.Left, n.Right}
+	case *ast.MatchesNode:
+		return []ast.Node{n.Left, n.Right}
+	case *ast.PropertyNode:
+		return []ast.Node{n.Node}
+	case *ast.IndexNode:
+		return []ast.Node{n.Node, n.Index}
+	case *ast.SliceNode:
+		children := []ast.Node{n.Node}
+		if n.From != nil {
+			children = append(children, n.From)
+		}
+		if n.To != nil {
+			children = append(children, n.To)
+		}
+		return children
+	case *ast.MethodNode:
+		return append([]ast.Node{n.Node}, n.Arguments...)
+	case *ast.FunctionNode:
+		return n.Arguments
+	case *ast.BuiltinNode:
+		return n.Arguments
+	case *ast.ClosureNode:
+		return []ast.Node{n.Node}
+	case *ast.ConditionalNode:
+		return []ast.Node{n.Cond, n.Exp1, n.Exp2}
+	case *ast.ArrayNode:
+		return n.Nodes
+	case *ast.MapNode:
+		return n.Pairs
+	case *ast.PairNode:
+		return []ast.Node{n.Key, n.Value}
+	}
+	return nil
+}
+
+// lookupPath returns the value of an attribute path in a set of attributes
+func lookupPath(attributes Attributes, path []string) (interface{}, bool) {
+	var value interface{} = attributes
+	for _, key := range path {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = m[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
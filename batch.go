@@ -0,0 +1,148 @@
+package leges
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"sync"
+)
+
+// MatchResult is the result of matching one request of a batch, or of
+// MatchWithObligations, see Match.
+type MatchResult struct {
+	Match  bool
+	Policy *Policy
+	Err    error
+	// Obligations and Advice are those of the decision, see Decision
+	Obligations map[string]interface{}
+	Advice      map[string]interface{}
+}
+
+// WithBatchConcurrency limits the number of requests of a batch that are
+// matched concurrently. The default is runtime.GOMAXPROCS(0).
+func WithBatchConcurrency(n int) Option {
+	return func(l *Leges) error {
+		l.batchConcurrency = n
+		return nil
+	}
+}
+
+// outcome is the result of evaluating the condition of a single policy
+type outcome struct {
+	ok  bool
+	err error
+}
+
+// subjectKey identifies the requests of a batch that share a subject, an
+// action and an environment. Subjects and environments are compared by
+// identity, so requests share the work only if they use the same Attributes
+// maps.
+type subjectKey struct {
+	subject     uintptr
+	action      string
+	environment uintptr
+}
+
+// subjectWork holds the outcomes of the policies that don't use the object,
+// which are the same for every request with the same subjectKey
+type subjectWork struct {
+	once     sync.Once
+	outcomes []outcome
+}
+
+// MatchBatch matches many requests concurrently and returns one result per
+// request, in the same order. Policies whose condition does not refer to the
+// object are only evaluated once for all requests with the same subject map,
+// environment map and action, which makes checking many objects for one
+// subject cheap.
+//
+// Unlike Match, MatchBatch evaluates obligations and advice like Decide, and
+// reports them in the results. A request whose obligation fails to evaluate
+// fails with the error of the obligation.
+func (l *Leges) MatchBatch(requests []Request) []MatchResult {
+	return l.MatchBatchContext(context.Background(), requests)
+}
+
+// MatchBatchContext is like MatchBatch, but stops matching as soon as ctx is
+// done. The remaining requests fail with the error of ctx.
+func (l *Leges) MatchBatchContext(ctx context.Context, requests []Request) []MatchResult {
+	results := make([]MatchResult, len(requests))
+
+	works := make(map[subjectKey]*subjectWork)
+	for _, request := range requests {
+		key := keyOf(request)
+		if _, ok := works[key]; !ok {
+			works[key] = &subjectWork{}
+		}
+	}
+
+	workers := l.batchConcurrency
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(requests) {
+		workers = len(requests)
+	}
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				request := requests[i]
+				if err := request.Validate(); err != nil {
+					results[i].Err = err
+					continue
+				}
+
+				work := works[keyOf(request)]
+				work.once.Do(func() {
+					work.outcomes = l.subjectOutcomes(ctx, request)
+				})
+
+				results[i] = l.decide(ctx, request, work.outcomes, decideObligations).matchResult()
+			}
+		}()
+	}
+
+	for i := range requests {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func keyOf(request Request) subjectKey {
+	return subjectKey{
+		subject:     reflect.ValueOf(request.Subject).Pointer(),
+		action:      request.Action,
+		environment: reflect.ValueOf(request.Environment).Pointer(),
+	}
+}
+
+// subjectOutcomes evaluates the policies of the requested action that don't
+// use the object
+func (l *Leges) subjectOutcomes(ctx context.Context, request Request) []outcome {
+	normalizedRequest := l.normalizeRequest(ctx, request)
+	outcomes := make([]outcome, len(l.cachedPolicies))
+	requested := l.requestedAction(request.Action)
+
+	for _, i := range l.index.candidates(requested) {
+		statute := l.cachedPolicies[i]
+		if statute.usesObject || !requested.appliesTo(statute.policy) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			outcomes[i].err = err
+			continue
+		}
+
+		outcomes[i].ok, outcomes[i].err = l.run(ctx, statute, request, normalizedRequest)
+	}
+
+	return outcomes
+}
@@ -0,0 +1,141 @@
+package leges_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchBatch(t *testing.T) {
+	var subjectEvaluations int64
+	count := func() bool {
+		atomic.AddInt64(&subjectEvaluations, 1)
+		return true
+	}
+
+	rules, err := leges.NewLeges([]leges.Policy{
+		{
+			ID:        "admin_can_view",
+			Condition: `subject.count() and subject.role == "admin"`,
+			Actions:   []string{"VIEW"},
+		},
+		{
+			ID:        "owner_can_view",
+			Condition: `object.owner_id == subject.id`,
+			Actions:   []string{"VIEW"},
+		},
+	}, nil, leges.WithBatchConcurrency(4))
+	require.NoError(t, err)
+
+	guest := leges.Attributes{"id": "user1", "role": "guest", "count": count}
+	admin := leges.Attributes{"id": "user2", "role": "admin", "count": count}
+
+	var requests []leges.Request
+	for i := 0; i < 100; i++ {
+		requests = append(requests, leges.Request{
+			Action:  "VIEW",
+			Subject: guest,
+			Object:  leges.Attributes{"owner_id": fmt.Sprintf("user%d", i)},
+		})
+	}
+	requests = append(requests,
+		leges.Request{
+			Action:  "VIEW",
+			Subject: admin,
+			Object:  leges.Attributes{"owner_id": "user1"},
+		},
+		leges.Request{
+			Action:  "VIEW",
+			Subject: guest,
+		},
+	)
+
+	results := rules.MatchBatch(requests)
+	require.Len(t, results, len(requests))
+
+	for i, result := range results[:100] {
+		require.NoError(t, result.Err)
+		require.Equal(t, i == 1, result.Match, "request %d", i)
+		if i == 1 {
+			require.Equal(t, "owner_can_view", result.Policy.ID)
+		}
+	}
+
+	require.NoError(t, results[100].Err)
+	require.Equal(t, true, results[100].Match)
+	require.Equal(t, "admin_can_view", results[100].Policy.ID)
+
+	require.Equal(t, leges.ErrEmptyObjectAttrs, results[101].Err)
+
+	// once for guest and once for admin
+	require.Equal(t, int64(2), atomic.LoadInt64(&subjectEvaluations))
+
+	t.Run("fail if context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		results := rules.MatchBatchContext(ctx, requests[:10])
+		for _, result := range results {
+			require.Equal(t, context.Canceled, result.Err)
+		}
+	})
+
+	t.Run("requests with different environments don't share work", func(t *testing.T) {
+		rules := mustNewLeges(t, []leges.Policy{
+			{
+				ID:        "mfa_can_view",
+				Condition: `env.mfa == true`,
+				Actions:   []string{"VIEW"},
+			},
+		}, nil)
+
+		object := leges.Attributes{"type": "page"}
+		results := rules.MatchBatch([]leges.Request{
+			{Action: "VIEW", Subject: guest, Object: object, Environment: leges.Attributes{"mfa": true}},
+			{Action: "VIEW", Subject: guest, Object: object, Environment: leges.Attributes{"mfa": false}},
+		})
+		require.True(t, results[0].Match)
+		require.False(t, results[1].Match)
+	})
+
+	t.Run("empty batch", func(t *testing.T) {
+		require.Empty(t, rules.MatchBatch(nil))
+	})
+}
+
+func BenchmarkMatchBatch(b *testing.B) {
+	rules, err := leges.NewLeges([]leges.Policy{
+		{
+			ID:        "admin_can_view",
+			Condition: `subject.role == "admin" and subject.active == true`,
+			Actions:   []string{"VIEW"},
+		},
+		{
+			ID:        "owner_can_view",
+			Condition: `object.owner_id == subject.id`,
+			Actions:   []string{"VIEW"},
+		},
+	}, nil)
+	require.NoError(b, err)
+
+	subject := leges.Attributes{"id": "user1", "role": "guest"}
+	var requests []leges.Request
+	for i := 0; i < 500; i++ {
+		requests = append(requests, leges.Request{
+			Action:  "VIEW",
+			Subject: subject,
+			Object:  leges.Attributes{"owner_id": fmt.Sprintf("user%d", i)},
+		})
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		benchResults = rules.MatchBatch(requests)
+	}
+}
+
+var benchResults []leges.MatchResult
@@ -0,0 +1,131 @@
+package leges_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchContext(t *testing.T) {
+	policies := []leges.Policy{
+		{
+			ID:        "slow",
+			Condition: `any(1..5000, {any(1..5000, {# < 0})})`,
+			Actions:   []string{"SLOW"},
+		},
+		{
+			ID:        "fast",
+			Condition: `subject.id == object.owner_id`,
+			Actions:   []string{"FAST"},
+		},
+	}
+
+	request := func(action string) leges.Request {
+		return leges.Request{
+			Action: action,
+			Subject: leges.Attributes{
+				"id": "user1",
+			},
+			Object: leges.Attributes{
+				"owner_id": "user1",
+			},
+		}
+	}
+
+	t.Run("error if context is done", func(t *testing.T) {
+		rules := mustNewLeges(t, policies, nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, _, err := rules.MatchContext(ctx, request("FAST"))
+		require.Equal(t, context.Canceled, err)
+	})
+
+	t.Run("match with context", func(t *testing.T) {
+		rules := mustNewLeges(t, policies, nil)
+
+		ok, policy, err := rules.MatchContext(context.Background(), request("FAST"))
+		require.NoError(t, err)
+		require.Equal(t, true, ok)
+		require.Equal(t, "fast", policy.ID)
+	})
+
+	t.Run("error if evaluation budget is exceeded", func(t *testing.T) {
+		rules, err := leges.NewLeges(policies, nil, leges.WithEvaluationTimeout(time.Millisecond))
+		require.NoError(t, err)
+
+		_, _, err = rules.MatchContext(context.Background(), request("SLOW"))
+		require.Error(t, err)
+
+		var budgetErr *leges.ErrBudgetExceeded
+		require.True(t, errors.As(err, &budgetErr))
+		require.Equal(t, time.Millisecond, budgetErr.Budget)
+
+		var runErr *leges.ErrExprRunFailed
+		require.True(t, errors.As(err, &runErr))
+		require.Equal(t, "slow", runErr.Policy.ID)
+		require.True(t, errors.Is(err, leges.ErrEvaluationTimeout))
+	})
+
+	t.Run("object filters are evaluated within the budget", func(t *testing.T) {
+		rules, err := leges.NewLeges([]leges.Policy{
+			{
+				ID:        "slow",
+				Condition: `any(1..5000, {any(1..5000, {# < 0})}) or object.owner_id == subject.id`,
+				Actions:   []string{"SLOW"},
+			},
+		}, nil, leges.WithEvaluationTimeout(time.Millisecond))
+		require.NoError(t, err)
+
+		_, err = rules.ObjectFilter(leges.Attributes{"id": "user1"}, "SLOW")
+		require.True(t, errors.Is(err, leges.ErrEvaluationTimeout), "error: %v", err)
+	})
+
+	t.Run("match within evaluation budget", func(t *testing.T) {
+		rules, err := leges.NewLeges(policies, nil, leges.WithEvaluationTimeout(time.Second))
+		require.NoError(t, err)
+
+		ok, policy, err := rules.MatchContext(context.Background(), request("FAST"))
+		require.NoError(t, err)
+		require.Equal(t, true, ok)
+		require.Equal(t, "fast", policy.ID)
+	})
+}
+
+func TestTooManyTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	wait := func() bool {
+		<-release
+		return true
+	}
+
+	rules, err := leges.NewLeges([]leges.Policy{
+		{ID: "blocked", Condition: `subject.wait()`, Actions: []string{"VIEW"}},
+	}, nil, leges.WithEvaluationTimeout(time.Millisecond))
+	require.NoError(t, err)
+
+	request := leges.Request{
+		Action:  "VIEW",
+		Subject: leges.Attributes{"wait": wait},
+		Object:  leges.Attributes{"type": "page"},
+	}
+
+	for i := 0; i < leges.MaxTimedOutEvaluations; i++ {
+		_, _, err := rules.Match(request)
+		require.True(t, errors.Is(err, leges.ErrEvaluationTimeout), "error: %v", err)
+	}
+
+	_, _, err = rules.Match(request)
+	require.True(t, errors.Is(err, leges.ErrTooManyTimeouts), "error: %v", err)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		ok, _, err := rules.Match(request)
+		return err == nil && ok
+	}, time.Second, time.Millisecond)
+}
@@ -7,30 +7,127 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/siadat/leges"
 	"github.com/siadat/leges/httpserver"
 )
 
 func main() {
 	var (
 		optsAddr       = flag.String("addr", ":5120", "HTTP bind address")
-		optsPolicyFile = flag.String("policies", "policies.yaml", "Policy file")
+		optsPolicyFile = flag.String("policies", "policies.yaml", "Policy file, reloaded on SIGHUP")
+		optsWatch      = flag.Duration("watch", 0, "Interval of checking the policy file for changes, 0 disables watching")
+		optsSchemaFile = flag.String("schema", "", "Optional attribute schema file, in YAML or JSON")
+		optsHierarchy  = flag.String("action-hierarchy", "", "Optional YAML file mapping each action to the actions it implies")
+		optsRolesFile  = flag.String("roles", "", "Optional YAML file of roles and their permissions")
+		optsDebug      = flag.Bool("debug", false, "Write the values conditions pass to debug() to stderr")
+		optsFillEnv    = flag.Bool("fill-environment", false, "Set env.time and env.ip of requests that don't set them")
+		optsTimeout    = flag.Duration("evaluation-timeout", 0, "Maximum time to evaluate a single policy condition, 0 means no limit")
+		optsAlgorithm  = flag.String("combining-algorithm", string(leges.DenyOverrides), "Policy combining algorithm: deny-overrides, permit-overrides, first-applicable or only-one-applicable")
+		optsFailure    = flag.String("failure-mode", string(leges.FailClosed), "What happens when a condition fails to evaluate: fail-closed or skip-errored")
 	)
 	flag.Parse()
 
-	yamlFile, err := os.Open(*optsPolicyFile)
-	if err != nil {
+	algorithm := leges.CombiningAlgorithm(*optsAlgorithm)
+	if err := algorithm.Validate(); err != nil {
 		panic(err)
 	}
 
-	policies, err := httpserver.LoadPoliciesFromYaml(yamlFile)
-	if err != nil {
+	failureMode := leges.FailureMode(*optsFailure)
+	if err := failureMode.Validate(); err != nil {
+		panic(err)
+	}
+
+	var schema *leges.Schema
+	if *optsSchemaFile != "" {
+		schemaFile, err := os.Open(*optsSchemaFile)
+		if err != nil {
+			panic(err)
+		}
+
+		schema, err = httpserver.LoadSchemaFromYaml(schemaFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var hierarchy leges.ActionHierarchy
+	if *optsHierarchy != "" {
+		hierarchyFile, err := os.Open(*optsHierarchy)
+		if err != nil {
+			panic(err)
+		}
+
+		hierarchy, err = httpserver.LoadActionHierarchyFromYaml(hierarchyFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var roles []leges.Role
+	if *optsRolesFile != "" {
+		rolesFile, err := os.Open(*optsRolesFile)
+		if err != nil {
+			panic(err)
+		}
+
+		roles, err = httpserver.LoadRolesFromYaml(rolesFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var tracer leges.Tracer
+	if *optsDebug {
+		tracer = leges.NewWriterTracer(os.Stderr)
+	}
+
+	handler := &httpserver.Server{
+		CombiningAlgorithm: algorithm,
+		FailureMode:        failureMode,
+		EvaluationTimeout:  *optsTimeout,
+		Schema:             schema,
+		ActionHierarchy:    hierarchy,
+		Roles:              roles,
+		Functions:          leges.StandardLibrary(),
+		Tracer:             tracer,
+		FillEnvironment:    *optsFillEnv,
+	}
+	if err := loadPolicyFile(handler, *optsPolicyFile); err != nil {
 		panic(err)
 	}
 
+	var reloadMu sync.Mutex
+	reload := func() {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+
+		if err := loadPolicyFile(handler, *optsPolicyFile); err != nil {
+			log.Printf("Reloading %s failed, still serving revision %d: %v", *optsPolicyFile, handler.Status().Revision, err)
+			return
+		}
+		log.Printf("Reloaded %s, serving revision %d", *optsPolicyFile, handler.Status().Revision)
+	}
+
+	go func() {
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		for range sighup {
+			log.Printf("Caught SIGHUP...")
+			reload()
+		}
+	}()
+
+	if *optsWatch > 0 {
+		go watchFile(*optsPolicyFile, *optsWatch, reload)
+	}
+
 	srv := http.Server{
 		Addr:    *optsAddr,
-		Handler: &httpserver.Server{Policies: policies},
+		Handler: handler,
 	}
 
 	idleConnsClosed := make(chan struct{})
@@ -54,3 +151,35 @@ func main() {
 	<-idleConnsClosed
 	log.Printf("à bientôt!")
 }
+
+func loadPolicyFile(handler *httpserver.Server, path string) error {
+	yamlFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer yamlFile.Close()
+
+	return handler.LoadPolicies(yamlFile)
+}
+
+// watchFile calls onChange whenever the modification time or the size of
+// the file at path changes
+func watchFile(path string, interval time.Duration, onChange func()) {
+	var last os.FileInfo
+	if info, err := os.Stat(path); err == nil {
+		last = info
+	}
+
+	for range time.Tick(interval) {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("Watching %s failed: %v", path, err)
+			continue
+		}
+
+		if last == nil || !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size() {
+			last = info
+			onChange()
+		}
+	}
+}
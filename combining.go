@@ -0,0 +1,156 @@
+package leges
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidCombiningAlgorithm  = errors.New("invalid combining algorithm")
+	ErrMultipleApplicablePolicies = errors.New("more than one policy is applicable")
+)
+
+// CombiningAlgorithm decides the result of a request when several policies
+// with different effects are applicable.
+type CombiningAlgorithm string
+
+const (
+	// DenyOverrides refuses the request if any applicable policy denies it,
+	// otherwise it grants the request if any applicable policy allows it.
+	// Allow policies that fail to evaluate only refuse the request if no
+	// other policy decides it. It is the default algorithm.
+	DenyOverrides CombiningAlgorithm = "deny-overrides"
+	// PermitOverrides grants the request if any applicable policy allows it,
+	// otherwise it refuses it. Deny policies that fail to evaluate only
+	// refuse the request as Indeterminate if no other policy decides it.
+	PermitOverrides CombiningAlgorithm = "permit-overrides"
+	// FirstApplicable uses the effect of the first applicable policy.
+	FirstApplicable CombiningAlgorithm = "first-applicable"
+	// OnlyOneApplicable uses the effect of the applicable policy and fails
+	// with ErrMultipleApplicablePolicies if there is more than one.
+	OnlyOneApplicable CombiningAlgorithm = "only-one-applicable"
+)
+
+func (a CombiningAlgorithm) Validate() error {
+	switch a {
+	case DenyOverrides, PermitOverrides, FirstApplicable, OnlyOneApplicable:
+		return nil
+	}
+	return fmt.Errorf("algorithm=%q: %w", a, ErrInvalidCombiningAlgorithm)
+}
+
+// combiner collects the applicable policies of a single request and
+// combines their effects using a combining algorithm.
+type combiner struct {
+	algorithm CombiningAlgorithm
+	allowed   *Policy
+	denied    *Policy
+	// applicable is the first applicable policy, used by FirstApplicable
+	// and OnlyOneApplicable
+	applicable *Policy
+	err        error
+	// pending is the error of a policy that failed to evaluate, which only
+	// makes the result Indeterminate if no other policy decides it
+	pending error
+}
+
+// add records an applicable policy and reports whether the result is final,
+// in which case the remaining policies need not be evaluated.
+func (c *combiner) add(policy *Policy) bool {
+	switch c.algorithm {
+	case FirstApplicable:
+		c.applicable = policy
+		return true
+	case OnlyOneApplicable:
+		if c.applicable != nil {
+			c.err = fmt.Errorf("ids=%q,%q: %w", c.applicable.ID, policy.ID, ErrMultipleApplicablePolicies)
+			return true
+		}
+		c.applicable = policy
+		return false
+	case PermitOverrides:
+		if policy.allows() {
+			c.allowed = policy
+			return true
+		}
+		if c.denied == nil {
+			c.denied = policy
+		}
+		return false
+	default:
+		if !policy.allows() {
+			c.denied = policy
+			return true
+		}
+		if c.allowed == nil {
+			c.allowed = policy
+		}
+		return false
+	}
+}
+
+// addError records a policy whose condition failed to evaluate with err, and
+// reports whether the remaining policies may still decide the request. Like
+// the Indeterminate{P} and Indeterminate{D} results of XACML, an error in an
+// allow policy can't override a deny under DenyOverrides, nor an allow that
+// already matched, and an error in a deny policy likewise under
+// PermitOverrides.
+func (c *combiner) addError(policy *Policy, err error) bool {
+	switch c.algorithm {
+	case FirstApplicable, OnlyOneApplicable:
+		return false
+	case PermitOverrides:
+		if policy.allows() {
+			return false
+		}
+	default:
+		if !policy.allows() {
+			return false
+		}
+	}
+	c.pending = err
+	return true
+}
+
+// settled reports whether the result is final given whether deny policies
+// remain to be evaluated, which is the case under DenyOverrides once a policy
+// allows the request and no deny policy remains.
+func (c *combiner) settled(denyRemains bool) bool {
+	switch c.algorithm {
+	case FirstApplicable, OnlyOneApplicable, PermitOverrides:
+		return false
+	default:
+		return c.allowed != nil && !denyRemains
+	}
+}
+
+// result returns whether the request is granted and the policy that decided it.
+func (c *combiner) result() (bool, *Policy, error) {
+	if c.err != nil {
+		return false, nil, c.err
+	}
+
+	switch c.algorithm {
+	case FirstApplicable, OnlyOneApplicable:
+		if c.applicable == nil {
+			return false, nil, nil
+		}
+		return c.applicable.allows(), c.applicable, nil
+	case PermitOverrides:
+		if c.allowed != nil {
+			return true, c.allowed, nil
+		}
+		if c.denied != nil {
+			return false, c.denied, nil
+		}
+		return false, nil, c.pending
+	default:
+		if c.denied != nil {
+			return false, c.denied, nil
+		}
+		if c.allowed != nil {
+			return true, c.allowed, nil
+		}
+		return false, nil, c.pending
+	}
+}
@@ -0,0 +1,153 @@
+package leges_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCombiningAlgorithms(t *testing.T) {
+	policies := []leges.Policy{
+		{
+			ID: "admins_can_edit_pages",
+			Condition: `
+				subject.role == "admin"
+				and object.type == "page"
+			`,
+			Actions: []string{
+				"EDIT",
+			},
+		},
+		{
+			ID: "nobody_can_edit_locked_pages",
+			Condition: `
+				object.type == "page"
+				and object.locked == true
+			`,
+			Actions: []string{
+				"EDIT",
+			},
+			Effect: leges.EffectDeny,
+		},
+	}
+
+	request := func(locked bool) leges.Request {
+		return leges.Request{
+			Action: "EDIT",
+			Subject: leges.Attributes{
+				"role": "admin",
+			},
+			Object: leges.Attributes{
+				"type":   "page",
+				"locked": locked,
+			},
+		}
+	}
+
+	testCases := []struct {
+		algorithm        leges.CombiningAlgorithm
+		locked           bool
+		expectedOk       bool
+		expectedPolicyID string
+		expectedErr      error
+	}{
+		{
+			algorithm:        leges.DenyOverrides,
+			locked:           false,
+			expectedOk:       true,
+			expectedPolicyID: "admins_can_edit_pages",
+		},
+		{
+			algorithm:        leges.DenyOverrides,
+			locked:           true,
+			expectedOk:       false,
+			expectedPolicyID: "nobody_can_edit_locked_pages",
+		},
+		{
+			algorithm:        leges.PermitOverrides,
+			locked:           true,
+			expectedOk:       true,
+			expectedPolicyID: "admins_can_edit_pages",
+		},
+		{
+			algorithm:        leges.FirstApplicable,
+			locked:           false,
+			expectedOk:       true,
+			expectedPolicyID: "admins_can_edit_pages",
+		},
+		{
+			algorithm:        leges.OnlyOneApplicable,
+			locked:           false,
+			expectedOk:       true,
+			expectedPolicyID: "admins_can_edit_pages",
+		},
+		{
+			algorithm:   leges.OnlyOneApplicable,
+			locked:      true,
+			expectedErr: leges.ErrMultipleApplicablePolicies,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(string(tt.algorithm), func(t *testing.T) {
+			rules, err := leges.NewLeges(policies, nil, leges.WithCombiningAlgorithm(tt.algorithm))
+			require.NoError(t, err)
+
+			ok, policy, err := rules.Match(request(tt.locked))
+			if tt.expectedErr != nil {
+				require.True(t, errors.Is(err, tt.expectedErr))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedOk, ok)
+			require.Equal(t, tt.expectedPolicyID, policy.ID)
+		})
+	}
+
+	t.Run("deny without any allow", func(t *testing.T) {
+		rules := mustNewLeges(t, policies[1:], nil)
+		ok, policy, err := rules.Match(request(true))
+		require.NoError(t, err)
+		require.Equal(t, false, ok)
+		require.Equal(t, "nobody_can_edit_locked_pages", policy.ID)
+	})
+
+	t.Run("errors of allow policies don't override an allow", func(t *testing.T) {
+		rules := mustNewLeges(t, []leges.Policy{
+			{ID: "p1", Condition: `true`, Actions: []string{"EDIT"}},
+			{ID: "p2", Condition: `subject.x.y == 1`, Actions: []string{"EDIT"}},
+			{ID: "p3", Condition: `subject.x.y == 1`, Actions: []string{"EDIT"}, Effect: leges.EffectDeny},
+		}, nil)
+
+		ok, policy, err := rules.Match(leges.Request{Action: "EDIT", Subject: leges.Attributes{"id": "user1"}, Object: leges.Attributes{"type": "page"}})
+		require.Error(t, err, "errors of deny policies still do")
+		require.False(t, ok)
+		require.Nil(t, policy)
+
+		rules = mustNewLeges(t, []leges.Policy{
+			{ID: "p1", Condition: `true`, Actions: []string{"EDIT"}},
+			{ID: "p2", Condition: `subject.x.y == 1`, Actions: []string{"EDIT"}},
+		}, nil)
+
+		ok, policy, err = rules.Match(leges.Request{Action: "EDIT", Subject: leges.Attributes{"id": "user1"}, Object: leges.Attributes{"type": "page"}})
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "p1", policy.ID)
+
+		rules = mustNewLeges(t, []leges.Policy{
+			{ID: "p1", Condition: `subject.x.y == 1`, Actions: []string{"EDIT"}},
+			{ID: "p2", Condition: `false`, Actions: []string{"EDIT"}},
+		}, nil)
+
+		_, _, err = rules.Match(leges.Request{Action: "EDIT", Subject: leges.Attributes{"id": "user1"}, Object: leges.Attributes{"type": "page"}})
+		require.Error(t, err, "unless no other policy decides")
+	})
+
+	t.Run("err if algorithm is invalid", func(t *testing.T) {
+		_, err := leges.NewLeges(policies, nil, leges.WithCombiningAlgorithm("most-popular"))
+		require.Error(t, err)
+		require.True(t, errors.Is(err, leges.ErrInvalidCombiningAlgorithm))
+	})
+}
@@ -0,0 +1,254 @@
+package leges
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidFailureMode = errors.New("invalid failure mode")
+
+// Verdict is the outcome of a Decision
+type Verdict string
+
+const (
+	// Permit means the request is granted
+	Permit Verdict = "permit"
+	// Deny means the request is refused by a deny policy, or by the
+	// combining algorithm
+	Deny Verdict = "deny"
+	// NotApplicable means no policy applies to the request, which refuses it
+	NotApplicable Verdict = "not-applicable"
+	// Indeterminate means the request could not be decided, because it is
+	// invalid or because of an error, which refuses it
+	Indeterminate Verdict = "indeterminate"
+)
+
+// Decision is the result of deciding a request, see Decide
+type Decision struct {
+	Verdict Verdict
+	// Policy is the policy that decided the verdict, it is nil if the verdict
+	// is NotApplicable or Indeterminate
+	Policy *Policy
+	// Policies are the applicable policies that were combined into the
+	// verdict, in evaluation order. Policies evaluated after the verdict was
+	// final are not included.
+	Policies []Policy
+	// Errors are the errors of the policies that failed to evaluate. If the
+	// verdict is Indeterminate, the last error is the one that made it so.
+	Errors []error
+	// Obligations are the obligations of the policies in Policies whose
+	// effect agrees with the verdict, by ID. If more than one policy has an
+	// obligation with the same ID, the first one in evaluation order is
+	// used. An obligation that fails to evaluate makes the verdict
+	// Indeterminate.
+	Obligations map[string]interface{}
+	// Advice is collected like Obligations, but advice that fails to
+	// evaluate is only reported in Errors
+	Advice map[string]interface{}
+}
+
+// Allowed reports whether the request is granted
+func (d Decision) Allowed() bool {
+	return d.Verdict == Permit
+}
+
+// result returns the decision the way Match does
+func (d Decision) result() (bool, *Policy, error) {
+	if d.Verdict == Indeterminate {
+		return false, nil, d.Errors[len(d.Errors)-1]
+	}
+	return d.Allowed(), d.Policy, nil
+}
+
+// matchResult returns the decision the way MatchWithObligations does
+func (d Decision) matchResult() MatchResult {
+	var result MatchResult
+	result.Match, result.Policy, result.Err = d.result()
+	result.Obligations, result.Advice = d.Obligations, d.Advice
+	return result
+}
+
+// FailureMode decides what happens when the condition of a policy fails to
+// evaluate.
+type FailureMode string
+
+const (
+	// FailClosed makes the decision Indeterminate as soon as a condition
+	// fails to evaluate, which refuses the request, unless the combining
+	// algorithm lets the other policies decide, see DenyOverrides and
+	// PermitOverrides. It is the default mode.
+	FailClosed FailureMode = "fail-closed"
+	// SkipErrored treats the policies whose condition fails to evaluate as
+	// not applicable, and carries on with the remaining policies. The
+	// errors are still reported in Decision.Errors. Requests whose context
+	// is done are Indeterminate in either mode.
+	SkipErrored FailureMode = "skip-errored"
+)
+
+func (m FailureMode) Validate() error {
+	switch m {
+	case FailClosed, SkipErrored:
+		return nil
+	}
+	return fmt.Errorf("mode=%q: %w", m, ErrInvalidFailureMode)
+}
+
+// WithFailureMode sets what happens when the condition of a policy fails to
+// evaluate. The default is FailClosed. It applies to every method that
+// evaluates conditions, such as Match, MatchAll, Decide and Explain.
+func WithFailureMode(mode FailureMode) Option {
+	return func(l *Leges) error {
+		if err := mode.Validate(); err != nil {
+			return err
+		}
+		l.failureMode = mode
+		return nil
+	}
+}
+
+// skipsErrors reports whether the policies that fail to evaluate should be
+// skipped
+func (l *Leges) skipsErrors(ctx context.Context) bool {
+	return l.failureMode == SkipErrored && ctx.Err() == nil
+}
+
+// Decide checks a request against policies like Match, but tells apart the
+// requests that no policy applies to from the ones that could not be decided,
+// and reports every applicable policy and error.
+func (l *Leges) Decide(request Request) Decision {
+	return l.DecideContext(context.Background(), request)
+}
+
+// DecideContext is like Decide, but stops evaluating policies as soon as ctx
+// is done, in which case the decision is Indeterminate with the error of ctx.
+func (l *Leges) DecideContext(ctx context.Context, request Request) Decision {
+	return l.decide(ctx, request, nil, decideObligations)
+}
+
+// decideMode tells decide what to evaluate besides the verdict
+type decideMode int
+
+const (
+	// decideVerdict only evaluates the policies needed for the verdict,
+	// without obligations and advice, so that callers who drop them, such
+	// as Match, don't fail because of them
+	decideVerdict decideMode = iota
+	// decideObligations also evaluates the obligations and the advice
+	decideObligations
+	// decideApplicable evaluates every applicable policy until the verdict
+	// is final, even the allow policies after a policy already allowed the
+	// request under DenyOverrides, since their fields are all visible
+	decideApplicable
+)
+
+// decide implements DecideContext. If subjectOutcomes is not nil, it has the
+// outcomes of the policies that don't use the object, indexed like
+// l.cachedPolicies, and they are used instead of evaluating those policies.
+func (l *Leges) decide(ctx context.Context, request Request, subjectOutcomes []outcome, mode decideMode) Decision {
+	var decision Decision
+	indeterminate := func(err error) Decision {
+		decision.Verdict = Indeterminate
+		decision.Policy = nil
+		decision.Errors = append(decision.Errors, err)
+		return decision
+	}
+
+	if err := request.Validate(); err != nil {
+		return indeterminate(err)
+	}
+
+	normalizedRequest := l.normalizeRequest(ctx, request)
+	c := combiner{algorithm: l.algorithm}
+	requested := l.requestedAction(request.Action)
+	lastDeny := l.index.lastDeny(requested)
+	var applicable []cachedPolicy
+
+	for _, i := range l.index.candidates(requested) {
+		if mode != decideApplicable && c.settled(i <= lastDeny) {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return indeterminate(err)
+		}
+
+		statute := l.cachedPolicies[i]
+		if !requested.appliesTo(statute.policy) || statute.guardsFail(request) {
+			continue
+		}
+
+		var (
+			ok  bool
+			err error
+		)
+		if subjectOutcomes != nil && !statute.usesObject {
+			ok, err = subjectOutcomes[i].ok, subjectOutcomes[i].err
+		} else {
+			ok, err = l.run(ctx, statute, request, normalizedRequest)
+		}
+		if err != nil {
+			policy := statute.policy
+			if !l.skipsErrors(ctx) && (ctx.Err() != nil || !c.addError(&policy, err)) {
+				return indeterminate(err)
+			}
+			decision.Errors = append(decision.Errors, err)
+			continue
+		}
+
+		if ok {
+			policy := statute.policy
+			decision.Policies = append(decision.Policies, policy)
+			applicable = append(applicable, statute)
+			if c.add(&policy) {
+				break
+			}
+		}
+	}
+
+	ok, policy, err := c.result()
+	switch {
+	case err != nil && c.pending != nil:
+		// the error of an allow policy, or of a deny policy under
+		// PermitOverrides, which is already in decision.Errors
+		decision.Verdict = Indeterminate
+		return decision
+	case err != nil:
+		return indeterminate(err)
+	case ok:
+		decision.Verdict = Permit
+	case policy != nil:
+		decision.Verdict = Deny
+	default:
+		decision.Verdict = NotApplicable
+	}
+	decision.Policy = policy
+
+	if mode != decideObligations {
+		return decision
+	}
+	for _, statute := range applicable {
+		if statute.policy.allows() != decision.Allowed() {
+			continue
+		}
+		if len(statute.obligations) > 0 {
+			if decision.Obligations == nil {
+				decision.Obligations = map[string]interface{}{}
+			}
+			if err := l.fulfill(ctx, statute, statute.obligations, request, normalizedRequest, decision.Obligations); err != nil {
+				decision.Obligations = nil
+				decision.Advice = nil
+				return indeterminate(err)
+			}
+		}
+		if len(statute.advice) > 0 {
+			if decision.Advice == nil {
+				decision.Advice = map[string]interface{}{}
+			}
+			if err := l.fulfill(ctx, statute, statute.advice, request, normalizedRequest, decision.Advice); err != nil {
+				decision.Errors = append(decision.Errors, err)
+			}
+		}
+	}
+
+	return decision
+}
@@ -0,0 +1,215 @@
+package leges_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecide(t *testing.T) {
+	policies := []leges.Policy{
+		{
+			ID:        "adults_can_view",
+			Condition: `subject.profile.age >= 18`,
+			Actions:   []string{"VIEW"},
+		},
+		{
+			ID:        "owners_can_view",
+			Condition: `object.owner_id == subject.id`,
+			Actions:   []string{"VIEW"},
+		},
+		{
+			ID:        "staff_can_view",
+			Condition: `subject.staff == true`,
+			Actions:   []string{"VIEW"},
+		},
+		{
+			ID:        "nobody_can_view_locked_pages",
+			Condition: `object.locked == true`,
+			Actions:   []string{"VIEW"},
+			Effect:    leges.EffectDeny,
+		},
+	}
+	owner := leges.Attributes{"id": "user1", "staff": true, "profile": leges.Attributes{"age": 30}}
+	stranger := leges.Attributes{"id": "user2", "profile": leges.Attributes{"age": 12}}
+	// subjects without a profile make adults_can_view fail to evaluate
+	noProfile := leges.Attributes{"id": "user1"}
+	page := leges.Attributes{"owner_id": "user1"}
+	otherPage := leges.Attributes{"owner_id": "user2"}
+	lockedPage := leges.Attributes{"owner_id": "user1", "locked": true}
+
+	policyIDs := func(policies []leges.Policy) []string {
+		var ids []string
+		for _, policy := range policies {
+			ids = append(ids, policy.ID)
+		}
+		return ids
+	}
+
+	testCases := []struct {
+		name             string
+		failureMode      leges.FailureMode
+		request          leges.Request
+		expectedVerdict  leges.Verdict
+		expectedPolicyID string
+		expectedPolicies []string
+		expectedErrors   int
+	}{
+		{
+			name:             "permit",
+			request:          leges.Request{Action: "VIEW", Subject: owner, Object: page},
+			expectedVerdict:  leges.Permit,
+			expectedPolicyID: "adults_can_view",
+			expectedPolicies: []string{"adults_can_view", "owners_can_view", "staff_can_view"},
+		},
+		{
+			name:             "deny",
+			request:          leges.Request{Action: "VIEW", Subject: owner, Object: lockedPage},
+			expectedVerdict:  leges.Deny,
+			expectedPolicyID: "nobody_can_view_locked_pages",
+			expectedPolicies: []string{"adults_can_view", "owners_can_view", "staff_can_view", "nobody_can_view_locked_pages"},
+		},
+		{
+			name:            "not applicable",
+			request:         leges.Request{Action: "VIEW", Subject: stranger, Object: page},
+			expectedVerdict: leges.NotApplicable,
+		},
+		{
+			name:            "not applicable to unknown actions",
+			request:         leges.Request{Action: "DELETE", Subject: owner, Object: page},
+			expectedVerdict: leges.NotApplicable,
+		},
+		{
+			name:            "indeterminate if the request is invalid",
+			request:         leges.Request{Action: "VIEW", Subject: owner},
+			expectedVerdict: leges.Indeterminate,
+			expectedErrors:  1,
+		},
+		{
+			name:            "fail closed",
+			failureMode:     leges.FailClosed,
+			request:         leges.Request{Action: "VIEW", Subject: noProfile, Object: otherPage},
+			expectedVerdict: leges.Indeterminate,
+			expectedErrors:  1,
+		},
+		{
+			name:             "errors of allow policies don't override an allow",
+			failureMode:      leges.FailClosed,
+			request:          leges.Request{Action: "VIEW", Subject: noProfile, Object: page},
+			expectedVerdict:  leges.Permit,
+			expectedPolicyID: "owners_can_view",
+			expectedPolicies: []string{"owners_can_view"},
+			expectedErrors:   1,
+		},
+		{
+			name:             "skip errored policies",
+			failureMode:      leges.SkipErrored,
+			request:          leges.Request{Action: "VIEW", Subject: noProfile, Object: page},
+			expectedVerdict:  leges.Permit,
+			expectedPolicyID: "owners_can_view",
+			expectedPolicies: []string{"owners_can_view"},
+			expectedErrors:   1,
+		},
+		{
+			name:             "skip errored policies but still deny",
+			failureMode:      leges.SkipErrored,
+			request:          leges.Request{Action: "VIEW", Subject: noProfile, Object: lockedPage},
+			expectedVerdict:  leges.Deny,
+			expectedPolicyID: "nobody_can_view_locked_pages",
+			expectedPolicies: []string{"owners_can_view", "nobody_can_view_locked_pages"},
+			expectedErrors:   1,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []leges.Option
+			if tt.failureMode != "" {
+				opts = append(opts, leges.WithFailureMode(tt.failureMode))
+			}
+			rules, err := leges.NewLeges(policies, nil, opts...)
+			require.NoError(t, err)
+
+			decision := rules.Decide(tt.request)
+			require.Equal(t, tt.expectedVerdict, decision.Verdict)
+			require.Equal(t, tt.expectedPolicies, policyIDs(decision.Policies))
+			require.Len(t, decision.Errors, tt.expectedErrors)
+			require.Equal(t, tt.expectedVerdict == leges.Permit, decision.Allowed())
+			if tt.expectedPolicyID == "" {
+				require.Nil(t, decision.Policy)
+			} else {
+				require.Equal(t, tt.expectedPolicyID, decision.Policy.ID)
+			}
+
+			// Match agrees with the decision
+			ok, policy, err := rules.Match(tt.request)
+			require.Equal(t, decision.Allowed(), ok)
+			require.Equal(t, decision.Policy, policy)
+			if tt.expectedVerdict == leges.Indeterminate {
+				require.Equal(t, decision.Errors[0], err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("evaluation errors are reported", func(t *testing.T) {
+		rules := mustNewLeges(t, policies, nil)
+		decision := rules.Decide(leges.Request{Action: "VIEW", Subject: noProfile, Object: page})
+
+		var runErr *leges.ErrExprRunFailed
+		require.True(t, errors.As(decision.Errors[0], &runErr))
+		require.Equal(t, "adults_can_view", runErr.Policy.ID)
+	})
+
+	t.Run("indeterminate if context is done, even when skipping errors", func(t *testing.T) {
+		rules, err := leges.NewLeges(policies, nil, leges.WithFailureMode(leges.SkipErrored))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		decision := rules.DecideContext(ctx, leges.Request{Action: "VIEW", Subject: owner, Object: page})
+		require.Equal(t, leges.Indeterminate, decision.Verdict)
+		require.Equal(t, []error{context.Canceled}, decision.Errors)
+	})
+
+	t.Run("indeterminate if more than one policy is applicable", func(t *testing.T) {
+		rules, err := leges.NewLeges(policies, nil, leges.WithCombiningAlgorithm(leges.OnlyOneApplicable))
+		require.NoError(t, err)
+
+		decision := rules.Decide(leges.Request{Action: "VIEW", Subject: owner, Object: page})
+		require.Equal(t, leges.Indeterminate, decision.Verdict)
+		require.True(t, errors.Is(decision.Errors[0], leges.ErrMultipleApplicablePolicies))
+	})
+
+	t.Run("skip errored policies in MatchAll, Explain and AllowedActions", func(t *testing.T) {
+		rules, err := leges.NewLeges(policies, nil, leges.WithFailureMode(leges.SkipErrored))
+		require.NoError(t, err)
+		request := leges.Request{Action: "VIEW", Subject: noProfile, Object: page}
+
+		matched, err := rules.MatchAll(request)
+		require.NoError(t, err)
+		require.Equal(t, []string{"owners_can_view"}, policyIDs(matched))
+
+		explanation, err := rules.Explain(request)
+		require.NoError(t, err)
+		require.NoError(t, explanation.Err)
+		require.True(t, explanation.Match)
+		require.Equal(t, leges.TraceError, explanation.Policies[0].Status)
+
+		actions, err := rules.AllowedActions(noProfile, page)
+		require.NoError(t, err)
+		require.Equal(t, []leges.AllowedAction{
+			{Action: "VIEW", PolicyIDs: []string{"owners_can_view"}},
+		}, actions)
+	})
+
+	t.Run("err if the failure mode is unknown", func(t *testing.T) {
+		_, err := leges.NewLeges(policies, nil, leges.WithFailureMode("fail-open"))
+		require.True(t, errors.Is(err, leges.ErrInvalidFailureMode))
+	})
+}
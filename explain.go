@@ -0,0 +1,239 @@
+package leges
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/antonmedv/expr/ast"
+	"github.com/antonmedv/expr/checker"
+	"github.com/antonmedv/expr/compiler"
+	"github.com/antonmedv/expr/parser"
+)
+
+// TraceStatus is the outcome of a single policy in an Explanation
+type TraceStatus string
+
+const (
+	// TraceSkipped means the policy does not include the requested action
+	TraceSkipped TraceStatus = "skipped"
+	// TraceTrue means the condition of the policy was true
+	TraceTrue TraceStatus = "true"
+	// TraceFalse means the condition of the policy was false
+	TraceFalse TraceStatus = "false"
+	// TraceError means the condition of the policy could not be evaluated
+	TraceError TraceStatus = "error"
+)
+
+// Explanation describes how a request was decided
+type Explanation struct {
+	// Match, Policy and Err are what Match would return for the request
+	Match  bool
+	Policy *Policy
+	Err    error
+	// Policies has one trace for each policy, in evaluation order
+	Policies []PolicyTrace
+}
+
+// PolicyTrace describes the evaluation of one policy
+type PolicyTrace struct {
+	PolicyID string      `json:"id"`
+	Status   TraceStatus `json:"status"`
+	// FalseExpressions are the sub-expressions of the condition that made it
+	// false, only set if Status is TraceFalse
+	FalseExpressions []ExpressionTrace `json:"false_expressions,omitempty"`
+	// Error is the evaluation error, only set if Status is TraceError
+	Error string `json:"error,omitempty"`
+	// Debug are the values the condition passed to debug()
+	Debug []interface{} `json:"debug,omitempty"`
+}
+
+// ExpressionTrace is a sub-expression of a condition along with the values of
+// the attributes it refers to
+type ExpressionTrace struct {
+	Expression string                 `json:"expression"`
+	Values     map[string]interface{} `json:"values,omitempty"`
+}
+
+// Explain evaluates every policy against a request and returns a trace of
+// each evaluation along with the result Match would return. The error is only
+// non-nil if the request is invalid.
+func (l *Leges) Explain(request Request) (*Explanation, error) {
+	return l.ExplainContext(context.Background(), request)
+}
+
+// ExplainContext is like Explain, but evaluates the policies with ctx, which
+// is also passed to the tracer along with its correlation ID.
+func (l *Leges) ExplainContext(ctx context.Context, request Request) (*Explanation, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	normalizedRequest := l.normalizeRequest(ctx, request)
+	c := combiner{algorithm: l.algorithm}
+	decided := false
+	requested := l.requestedAction(request.Action)
+	explanation := &Explanation{
+		Policies: make([]PolicyTrace, 0, len(l.cachedPolicies)),
+	}
+
+	for _, statute := range l.cachedPolicies {
+		trace := PolicyTrace{
+			PolicyID: statute.policy.ID,
+		}
+
+		if !requested.appliesTo(statute.policy) {
+			trace.Status = TraceSkipped
+			explanation.Policies = append(explanation.Policies, trace)
+			continue
+		}
+
+		// each policy gets its own copy of the request, to collect its
+		// debug values. An evaluation that timed out may still be adding
+		// values in the background.
+		env := Attributes{}
+		for k, v := range normalizedRequest {
+			env[k] = v
+		}
+		debugValues := &debugCollector{}
+		env["debug"] = l.debugFunc(ctx, debugValues.add)
+
+		ok, err := l.run(ctx, statute, request, env)
+		trace.Debug = debugValues.collected()
+		switch {
+		case err != nil:
+			trace.Status = TraceError
+			trace.Error = err.Error()
+			if runErr, isRunErr := err.(*ErrExprRunFailed); isRunErr {
+				trace.Error = runErr.Err.Error()
+			}
+			policy := statute.policy
+			if !decided && !l.skipsErrors(ctx) && (ctx.Err() != nil || !c.addError(&policy, err)) {
+				explanation.Err = err
+				decided = true
+			}
+		case ok:
+			trace.Status = TraceTrue
+			if !decided {
+				policy := statute.policy
+				decided = c.add(&policy)
+			}
+		default:
+			trace.Status = TraceFalse
+			// the sub-expressions are evaluated quietly
+			env["debug"] = debugNop
+			trace.FalseExpressions = l.explainFalse(ctx, statute, request, resolvedEnv(env))
+		}
+
+		explanation.Policies = append(explanation.Policies, trace)
+	}
+
+	if explanation.Err == nil {
+		explanation.Match, explanation.Policy, explanation.Err = c.result()
+	}
+
+	return explanation, nil
+}
+
+// debugCollector collects the debug values of a single policy
+type debugCollector struct {
+	mu     sync.Mutex
+	values []interface{}
+}
+
+func (c *debugCollector) add(value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values = append(c.values, value)
+}
+
+func (c *debugCollector) collected() []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]interface{}(nil), c.values...)
+}
+
+// explainFalse returns the sub-expressions of a false condition that made it
+// false. The condition is parsed again, because checking the sub-expressions
+// modifies the tree.
+func (l *Leges) explainFalse(ctx context.Context, statute cachedPolicy, request Request, env Attributes) []ExpressionTrace {
+	tree, err := parser.Parse(statute.policy.Condition)
+	if err != nil {
+		return nil
+	}
+	if _, err := checker.Check(tree, nil); err != nil {
+		return nil
+	}
+
+	e := &falseExplainer{
+		leges:   l,
+		ctx:     ctx,
+		statute: statute,
+		request: request,
+		tree:    tree,
+		env:     env,
+	}
+	return e.falseExpressions(tree.Node)
+}
+
+// falseExplainer looks for the sub-expressions that made a condition false,
+// evaluating them like the condition, with the evaluation budget and ctx
+type falseExplainer struct {
+	leges   *Leges
+	ctx     context.Context
+	statute cachedPolicy
+	request Request
+	tree    *parser.Tree
+	env     Attributes
+}
+
+func (e *falseExplainer) falseExpressions(node ast.Node) []ExpressionTrace {
+	if n, ok := node.(*ast.BinaryNode); ok {
+		switch n.Operator {
+		case "and", "&&":
+			// the right operand is only evaluated if the left one is true,
+			// like the condition itself does
+			if e.isFalse(n.Left) {
+				return e.falseExpressions(n.Left)
+			}
+			if e.isFalse(n.Right) {
+				return e.falseExpressions(n.Right)
+			}
+		case "or", "||":
+			return append(e.falseExpressions(n.Left), e.falseExpressions(n.Right)...)
+		}
+	}
+
+	trace := ExpressionTrace{
+		Expression: formatNode(node),
+	}
+	for _, path := range attributePaths(node) {
+		if trace.Values == nil {
+			trace.Values = map[string]interface{}{}
+		}
+		trace.Values[strings.Join(path, ".")], _ = lookupPath(e.env, path)
+	}
+
+	return []ExpressionTrace{trace}
+}
+
+// isFalse evaluates a sub-expression of the checked tree and reports whether
+// it is false. Sub-expressions that fail to evaluate, or whose evaluation
+// exceeds the budget, are not considered false.
+func (e *falseExplainer) isFalse(node ast.Node) bool {
+	if e.ctx.Err() != nil {
+		return false
+	}
+
+	program, err := compiler.Compile(&parser.Tree{Node: node, Source: e.tree.Source}, nil)
+	if err != nil {
+		return false
+	}
+
+	output, err := e.leges.evaluate(e.ctx, e.statute, program, e.request, e.env)
+	if err != nil {
+		return false
+	}
+
+	return output == false
+}
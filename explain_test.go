@@ -0,0 +1,153 @@
+package leges_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	rules := mustNewLeges(t, []leges.Policy{
+		{
+			ID: "admin_can_update_pages",
+			Condition: `
+				subject.role == "admin"
+				and object.type in ["page", "adminpage"]
+			`,
+			Actions: []string{"VIEW", "UPDATE"},
+		},
+		{
+			ID: "owner_or_editor_can_update",
+			Condition: `
+				object.owner_id == subject.id
+				or (subject.role == "editor" and object.type == "page")
+			`,
+			Actions: []string{"UPDATE"},
+		},
+		{
+			ID:        "guest_can_signup",
+			Condition: `subject.role == "guest"`,
+			Actions:   []string{"SIGNUP"},
+		},
+		{
+			ID:        "broken",
+			Condition: `subject.tags[5] == "x"`,
+			Actions:   []string{"UPDATE"},
+		},
+	}, nil)
+
+	t.Run("explain a denial", func(t *testing.T) {
+		explanation, err := rules.Explain(leges.Request{
+			Action: "UPDATE",
+			Subject: leges.Attributes{
+				"id":   "user1",
+				"role": "guest",
+				"tags": []interface{}{},
+			},
+			Object: leges.Attributes{
+				"type":     "page",
+				"owner_id": "user2",
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, false, explanation.Match)
+		require.Nil(t, explanation.Policy)
+		require.Error(t, explanation.Err)
+		require.IsType(t, &leges.ErrExprRunFailed{}, explanation.Err)
+
+		require.Len(t, explanation.Policies, 4)
+
+		require.Equal(t, leges.PolicyTrace{
+			PolicyID: "admin_can_update_pages",
+			Status:   leges.TraceFalse,
+			FalseExpressions: []leges.ExpressionTrace{
+				{
+					Expression: `subject.role == "admin"`,
+					Values:     map[string]interface{}{"subject.role": "guest"},
+				},
+			},
+		}, explanation.Policies[0])
+
+		require.Equal(t, leges.PolicyTrace{
+			PolicyID: "owner_or_editor_can_update",
+			Status:   leges.TraceFalse,
+			FalseExpressions: []leges.ExpressionTrace{
+				{
+					Expression: `object.owner_id == subject.id`,
+					Values:     map[string]interface{}{"object.owner_id": "user2", "subject.id": "user1"},
+				},
+				{
+					Expression: `subject.role == "editor"`,
+					Values:     map[string]interface{}{"subject.role": "guest"},
+				},
+			},
+		}, explanation.Policies[1])
+
+		require.Equal(t, leges.PolicyTrace{
+			PolicyID: "guest_can_signup",
+			Status:   leges.TraceSkipped,
+		}, explanation.Policies[2])
+
+		require.Equal(t, "broken", explanation.Policies[3].PolicyID)
+		require.Equal(t, leges.TraceError, explanation.Policies[3].Status)
+		require.NotEmpty(t, explanation.Policies[3].Error)
+	})
+
+	t.Run("explain a match", func(t *testing.T) {
+		explanation, err := rules.Explain(leges.Request{
+			Action: "VIEW",
+			Subject: leges.Attributes{
+				"role": "admin",
+			},
+			Object: leges.Attributes{
+				"type": "adminpage",
+			},
+		})
+		require.NoError(t, err)
+		require.NoError(t, explanation.Err)
+		require.Equal(t, true, explanation.Match)
+		require.Equal(t, "admin_can_update_pages", explanation.Policy.ID)
+		require.Equal(t, leges.TraceTrue, explanation.Policies[0].Status)
+	})
+
+	t.Run("sub-expressions are evaluated within the budget", func(t *testing.T) {
+		rules, err := leges.NewLeges([]leges.Policy{
+			{
+				ID:        "slow",
+				Condition: `subject.a == 1 and any(1..5000, {any(1..5000, {# < 0})})`,
+				Actions:   []string{"VIEW"},
+			},
+		}, nil, leges.WithEvaluationTimeout(10*time.Millisecond))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		explanation, err := rules.ExplainContext(ctx, leges.Request{
+			Action:  "VIEW",
+			Subject: leges.Attributes{"a": 2},
+			Object:  leges.Attributes{"type": "page"},
+		})
+		require.NoError(t, err)
+		require.Less(t, int64(time.Since(start)), int64(time.Second))
+		require.Equal(t, leges.TraceFalse, explanation.Policies[0].Status)
+		// the right operand is never reached, so it is not evaluated
+		require.Equal(t, []leges.ExpressionTrace{
+			{Expression: "subject.a == 1", Values: map[string]interface{}{"subject.a": 2}},
+		}, explanation.Policies[0].FalseExpressions)
+	})
+
+	t.Run("error if request is invalid", func(t *testing.T) {
+		_, err := rules.Explain(leges.Request{
+			Action: "VIEW",
+			Object: leges.Attributes{
+				"type": "adminpage",
+			},
+		})
+		require.Equal(t, leges.ErrEmptySubjectAttrs, err)
+	})
+}
@@ -0,0 +1,107 @@
+package leges
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidField = errors.New("invalid field")
+
+// validateField checks a field of a policy, such as "email" or "address.*"
+func validateField(policy Policy, field string) error {
+	if !policy.allows() {
+		return fmt.Errorf("policy=%q field=%q: deny policies can't have fields: %w", policy.ID, field, ErrInvalidField)
+	}
+	for _, segment := range strings.Split(field, ".") {
+		if segment == "" {
+			return fmt.Errorf("policy=%q field=%q: %w", policy.ID, field, ErrInvalidField)
+		}
+	}
+	return nil
+}
+
+// FilterAttributes returns a copy of object with only the fields the request
+// may see. If the request has no object, object is used as the object of the
+// request as well.
+//
+// Nothing is visible unless the request is granted. The visible fields are
+// then the Fields of the allow policies that decided the request, see
+// Decision.Policies, or every field if one of them has no Fields.
+func (l *Leges) FilterAttributes(request Request, object Attributes) (Attributes, error) {
+	return l.FilterAttributesContext(context.Background(), request, object)
+}
+
+// FilterAttributesContext is like FilterAttributes, but stops evaluating
+// policies and returns the error of ctx as soon as ctx is done.
+func (l *Leges) FilterAttributesContext(ctx context.Context, request Request, object Attributes) (Attributes, error) {
+	if len(request.Object) == 0 {
+		request.Object = object
+	}
+
+	decision := l.decide(ctx, request, nil, decideApplicable)
+	ok, _, err := decision.result()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return Attributes{}, nil
+	}
+
+	var visible [][]string
+	for _, policy := range decision.Policies {
+		if !policy.allows() {
+			continue
+		}
+		if len(policy.Fields) == 0 {
+			return redact(object, nil), nil
+		}
+		for _, field := range policy.Fields {
+			visible = append(visible, strings.Split(field, "."))
+		}
+	}
+
+	return redact(object, visible), nil
+}
+
+// redact returns a copy of object with the fields matching visible, or every
+// field if visible is nil. Fields are split into their segments, and a field
+// matches the nested fields of the fields it matches.
+func redact(object Attributes, visible [][]string) Attributes {
+	redacted := Attributes{}
+
+	for key, value := range object {
+		var childVisible [][]string
+		isVisible := visible == nil
+		for _, field := range visible {
+			if !matchAction(field[0], key) {
+				continue
+			}
+			if len(field) == 1 {
+				isVisible = true
+				break
+			}
+			childVisible = append(childVisible, field[1:])
+		}
+
+		if isVisible {
+			redacted[key] = copyValue(value)
+			continue
+		}
+		if nested, isMap := value.(Attributes); isMap && childVisible != nil {
+			redacted[key] = redact(nested, childVisible)
+		}
+	}
+
+	return redacted
+}
+
+// copyValue copies the nested attributes of a visible value, so that the
+// redacted copy doesn't share them with the object
+func copyValue(value interface{}) interface{} {
+	if nested, isMap := value.(Attributes); isMap {
+		return redact(nested, nil)
+	}
+	return value
+}
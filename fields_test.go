@@ -0,0 +1,193 @@
+package leges_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterAttributes(t *testing.T) {
+	policies := []leges.Policy{
+		{
+			ID:        "members_can_view_profiles",
+			Condition: `subject.member == true`,
+			Actions:   []string{"VIEW"},
+			Fields:    []string{"id", "name", "address.city"},
+		},
+		{
+			ID:        "owners_can_view_their_profile",
+			Condition: `object.id == subject.id`,
+			Actions:   []string{"VIEW"},
+		},
+		{
+			ID:        "support_can_view_contact_details",
+			Condition: `subject.team == "support"`,
+			Actions:   []string{"VIEW"},
+			Fields:    []string{"id", "email", "address"},
+		},
+		{
+			ID:        "support_can_view_billing_in_the_office",
+			Condition: `subject.team == "support" and env.office == true`,
+			Actions:   []string{"VIEW"},
+			Fields:    []string{"billing_*"},
+		},
+		{
+			ID:        "nobody_can_view_banned_profiles",
+			Condition: `object.banned == true`,
+			Actions:   []string{"VIEW"},
+			Effect:    leges.EffectDeny,
+		},
+	}
+	rules := mustNewLeges(t, policies, nil)
+
+	profile := leges.Attributes{
+		"id":    "user1",
+		"name":  "Alice",
+		"email": "alice@example.com",
+		"address": leges.Attributes{
+			"city":   "Paris",
+			"street": "1 Rue de Rivoli",
+		},
+		"billing_iban": "FR76",
+		"billing_plan": "pro",
+	}
+
+	testCases := []struct {
+		name        string
+		subject     leges.Attributes
+		object      leges.Attributes
+		environment leges.Attributes
+		expected    leges.Attributes
+	}{
+		{
+			name:    "only the fields of the applicable allow policies",
+			subject: leges.Attributes{"id": "user2", "member": true},
+			expected: leges.Attributes{
+				"id":      "user1",
+				"name":    "Alice",
+				"address": leges.Attributes{"city": "Paris"},
+			},
+		},
+		{
+			name:    "fields of several policies are combined",
+			subject: leges.Attributes{"id": "user2", "member": true, "team": "support"},
+			expected: leges.Attributes{
+				"id":      "user1",
+				"name":    "Alice",
+				"email":   "alice@example.com",
+				"address": leges.Attributes{"city": "Paris", "street": "1 Rue de Rivoli"},
+			},
+		},
+		{
+			name:        "patterns match many fields",
+			subject:     leges.Attributes{"id": "user2", "team": "support"},
+			environment: leges.Attributes{"office": true},
+			expected: leges.Attributes{
+				"id":           "user1",
+				"email":        "alice@example.com",
+				"address":      leges.Attributes{"city": "Paris", "street": "1 Rue de Rivoli"},
+				"billing_iban": "FR76",
+				"billing_plan": "pro",
+			},
+		},
+		{
+			name:     "every field of allow policies without fields",
+			subject:  leges.Attributes{"id": "user1", "member": true},
+			expected: profile,
+		},
+		{
+			name:     "nothing if the request is not granted",
+			subject:  leges.Attributes{"id": "user2"},
+			expected: leges.Attributes{},
+		},
+		{
+			name:     "nothing if the request is denied",
+			subject:  leges.Attributes{"id": "user1"},
+			object:   leges.Attributes{"id": "user1", "banned": true},
+			expected: leges.Attributes{},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			filtered, err := rules.FilterAttributes(leges.Request{
+				Action:      "VIEW",
+				Subject:     tt.subject,
+				Object:      tt.object,
+				Environment: tt.environment,
+			}, profile)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, filtered)
+		})
+	}
+
+	t.Run("only the fields of the policies that decided", func(t *testing.T) {
+		for _, algorithm := range []leges.CombiningAlgorithm{leges.FirstApplicable, leges.PermitOverrides} {
+			rules, err := leges.NewLeges([]leges.Policy{
+				{
+					ID:        "members_can_view_names",
+					Condition: `subject.member == true`,
+					Actions:   []string{"VIEW"},
+					Fields:    []string{"name"},
+					Priority:  10,
+				},
+				{
+					ID:        "anyone_can_view_profiles",
+					Condition: `true`,
+					Actions:   []string{"VIEW"},
+				},
+			}, nil, leges.WithCombiningAlgorithm(algorithm))
+			require.NoError(t, err)
+
+			filtered, err := rules.FilterAttributes(leges.Request{
+				Action:  "VIEW",
+				Subject: leges.Attributes{"id": "user2", "member": true},
+			}, leges.Attributes{"name": "Alice", "ssn": "123"})
+			require.NoError(t, err)
+			require.Equal(t, leges.Attributes{"name": "Alice"}, filtered, "algorithm: %s", algorithm)
+		}
+	})
+
+	t.Run("the object is not shared", func(t *testing.T) {
+		filtered, err := rules.FilterAttributes(leges.Request{
+			Action:  "VIEW",
+			Subject: leges.Attributes{"id": "user1"},
+		}, profile)
+		require.NoError(t, err)
+
+		filtered["address"].(leges.Attributes)["city"] = "Lyon"
+		require.Equal(t, "Paris", profile["address"].(leges.Attributes)["city"])
+	})
+
+	t.Run("err if the request is invalid", func(t *testing.T) {
+		_, err := rules.FilterAttributes(leges.Request{Action: "VIEW"}, profile)
+		require.Equal(t, leges.ErrEmptySubjectAttrs, err)
+	})
+
+	t.Run("err if a field is invalid", func(t *testing.T) {
+		_, err := leges.NewLeges([]leges.Policy{
+			{
+				ID:        "anyone_can_view",
+				Condition: `true`,
+				Actions:   []string{"VIEW"},
+				Fields:    []string{"address..city"},
+			},
+		}, nil)
+		require.True(t, errors.Is(err, leges.ErrInvalidField))
+	})
+
+	t.Run("err if a deny policy has fields", func(t *testing.T) {
+		_, err := leges.NewLeges([]leges.Policy{
+			{
+				ID:        "nobody_can_view_emails",
+				Condition: `true`,
+				Actions:   []string{"VIEW"},
+				Effect:    leges.EffectDeny,
+				Fields:    []string{"email"},
+			},
+		}, nil)
+		require.True(t, errors.Is(err, leges.ErrInvalidField))
+	})
+}
@@ -0,0 +1,215 @@
+package leges
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrUnsupportedFilter = errors.New("residual can't be translated to a filter")
+	ErrInvalidColumn     = errors.New("invalid column name")
+)
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ColumnMapper maps the path of an attribute, including the root, to a SQL
+// column. The result is put in the query as is, so it must be trusted.
+type ColumnMapper func(field []string) (string, error)
+
+// DefaultColumn maps object.owner_id to owner_id and object.owner.id to
+// owner.id. Every part of the path must be a plain SQL identifier.
+func DefaultColumn(field []string) (string, error) {
+	if len(field) < 2 {
+		return "", fmt.Errorf("field=%q: %w", strings.Join(field, "."), ErrInvalidColumn)
+	}
+	for _, name := range field[1:] {
+		if !sqlIdentifier.MatchString(name) {
+			return "", fmt.Errorf("field=%q: %w", strings.Join(field, "."), ErrInvalidColumn)
+		}
+	}
+	return strings.Join(field[1:], "."), nil
+}
+
+// SQL translates a residual to a SQL WHERE fragment with "?" placeholders and
+// the values bound to them. If column is nil, DefaultColumn is used.
+//
+// Comparisons with nil become IS NULL and IS NOT NULL. Note that SQL compares
+// other values with NULL columns as unknown, so a row with a NULL column
+// doesn't match "column != ?" even though the condition would hold for it.
+// The "contains" operator is not supported.
+func SQL(residual Residual, column ColumnMapper) (string, []interface{}, error) {
+	if column == nil {
+		column = DefaultColumn
+	}
+
+	var args []interface{}
+	where, err := sqlResidual(residual, column, &args)
+	if err != nil {
+		return "", nil, err
+	}
+	return where, args, nil
+}
+
+func sqlResidual(residual Residual, column ColumnMapper, args *[]interface{}) (string, error) {
+	switch r := residual.(type) {
+	case Constant:
+		if r {
+			return "1 = 1", nil
+		}
+		return "1 = 0", nil
+	case And:
+		if len(r) == 0 {
+			return "1 = 1", nil
+		}
+		return sqlJoin(r, " AND ", column, args)
+	case Or:
+		if len(r) == 0 {
+			return "1 = 0", nil
+		}
+		return sqlJoin(r, " OR ", column, args)
+	case Not:
+		operand, err := sqlResidual(r.Residual, column, args)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + operand + ")", nil
+	case Comparison:
+		return sqlComparison(r, column, args)
+	}
+	return "", fmt.Errorf("%T: %w", residual, ErrUnsupportedFilter)
+}
+
+func sqlJoin(residuals []Residual, separator string, column ColumnMapper, args *[]interface{}) (string, error) {
+	operands := make([]string, len(residuals))
+	for i, residual := range residuals {
+		operand, err := sqlResidual(residual, column, args)
+		if err != nil {
+			return "", err
+		}
+		operands[i] = "(" + operand + ")"
+	}
+	return strings.Join(operands, separator), nil
+}
+
+func sqlComparison(c Comparison, column ColumnMapper, args *[]interface{}) (string, error) {
+	name, err := column(c.Field)
+	if err != nil {
+		return "", err
+	}
+
+	switch c.Operator {
+	case "==", "!=":
+		operator := "="
+		if c.Operator == "!=" {
+			operator = "<>"
+		}
+		if c.Value == nil {
+			if c.Operator == "==" {
+				return name + " IS NULL", nil
+			}
+			return name + " IS NOT NULL", nil
+		}
+		*args = append(*args, c.Value)
+		return name + " " + operator + " ?", nil
+	case "<", "<=", ">", ">=":
+		*args = append(*args, c.Value)
+		return name + " " + c.Operator + " ?", nil
+	case "in":
+		values, _ := c.Value.([]interface{})
+		if len(values) == 0 {
+			return "1 = 0", nil
+		}
+		placeholders := make([]string, len(values))
+		for i, value := range values {
+			placeholders[i] = "?"
+			*args = append(*args, value)
+		}
+		return name + " IN (" + strings.Join(placeholders, ", ") + ")", nil
+	}
+	return "", fmt.Errorf("%s: %w", c, ErrUnsupportedFilter)
+}
+
+// MongoFilter translates a residual to a MongoDB query filter document.
+// Attributes are referred to by their dotted path without the root, so
+// object.owner.id becomes "owner.id".
+func MongoFilter(residual Residual) (map[string]interface{}, error) {
+	switch r := residual.(type) {
+	case Constant:
+		if r {
+			return map[string]interface{}{}, nil
+		}
+		return map[string]interface{}{"$expr": false}, nil
+	case And:
+		if len(r) == 0 {
+			return map[string]interface{}{}, nil
+		}
+		operands, err := mongoFilters(r)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"$and": operands}, nil
+	case Or:
+		if len(r) == 0 {
+			return map[string]interface{}{"$expr": false}, nil
+		}
+		operands, err := mongoFilters(r)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"$or": operands}, nil
+	case Not:
+		operand, err := MongoFilter(r.Residual)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"$nor": []interface{}{operand}}, nil
+	case Comparison:
+		return mongoComparison(r)
+	}
+	return nil, fmt.Errorf("%T: %w", residual, ErrUnsupportedFilter)
+}
+
+var mongoOperators = map[string]string{
+	"==": "$eq",
+	"!=": "$ne",
+	"<":  "$lt",
+	"<=": "$lte",
+	">":  "$gt",
+	">=": "$gte",
+	"in": "$in",
+}
+
+func mongoFilters(residuals []Residual) ([]interface{}, error) {
+	filters := make([]interface{}, len(residuals))
+	for i, residual := range residuals {
+		filter, err := MongoFilter(residual)
+		if err != nil {
+			return nil, err
+		}
+		filters[i] = filter
+	}
+	return filters, nil
+}
+
+func mongoComparison(c Comparison) (map[string]interface{}, error) {
+	if len(c.Field) < 2 {
+		return nil, fmt.Errorf("field=%q: %w", strings.Join(c.Field, "."), ErrInvalidColumn)
+	}
+	field := strings.Join(c.Field[1:], ".")
+
+	if c.Operator == "contains" {
+		return map[string]interface{}{
+			field: map[string]interface{}{"$elemMatch": map[string]interface{}{"$eq": c.Value}},
+		}, nil
+	}
+
+	operator, ok := mongoOperators[c.Operator]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", c, ErrUnsupportedFilter)
+	}
+	return map[string]interface{}{
+		field: map[string]interface{}{operator: c.Value},
+	}, nil
+}
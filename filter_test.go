@@ -0,0 +1,85 @@
+package leges_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQL(t *testing.T) {
+	residual := leges.And{
+		leges.Or{
+			leges.Comparison{Field: []string{"object", "owner_id"}, Operator: "==", Value: "user1"},
+			leges.Comparison{Field: []string{"object", "type"}, Operator: "in", Value: []interface{}{"page", "post"}},
+		},
+		leges.Not{Residual: leges.Comparison{Field: []string{"object", "archived_at"}, Operator: "==", Value: nil}},
+		leges.Comparison{Field: []string{"object", "size"}, Operator: "<=", Value: 100},
+	}
+
+	t.Run("default columns", func(t *testing.T) {
+		where, args, err := leges.SQL(residual, nil)
+		require.NoError(t, err)
+		require.Equal(t, `((owner_id = ?) OR (type IN (?, ?))) AND (NOT (archived_at IS NULL)) AND (size <= ?)`, where)
+		require.Equal(t, []interface{}{"user1", "page", "post", 100}, args)
+	})
+
+	t.Run("custom columns", func(t *testing.T) {
+		where, args, err := leges.SQL(leges.Comparison{Field: []string{"object", "owner", "id"}, Operator: "!=", Value: 1}, func(field []string) (string, error) {
+			return `"owner_id"`, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, `"owner_id" <> ?`, where)
+		require.Equal(t, []interface{}{1}, args)
+	})
+
+	t.Run("constants", func(t *testing.T) {
+		where, args, err := leges.SQL(leges.Constant(false), nil)
+		require.NoError(t, err)
+		require.Equal(t, `1 = 0`, where)
+		require.Empty(t, args)
+	})
+
+	t.Run("error on invalid column", func(t *testing.T) {
+		_, _, err := leges.SQL(leges.Comparison{Field: []string{"object", "id; DROP TABLE pages"}, Operator: "==", Value: 1}, nil)
+		require.True(t, errors.Is(err, leges.ErrInvalidColumn))
+	})
+
+	t.Run("error on contains", func(t *testing.T) {
+		_, _, err := leges.SQL(leges.Comparison{Field: []string{"object", "members"}, Operator: "contains", Value: "user1"}, nil)
+		require.True(t, errors.Is(err, leges.ErrUnsupportedFilter))
+	})
+}
+
+func TestMongoFilter(t *testing.T) {
+	residual := leges.And{
+		leges.Or{
+			leges.Comparison{Field: []string{"object", "owner", "id"}, Operator: "==", Value: "user1"},
+			leges.Comparison{Field: []string{"object", "members"}, Operator: "contains", Value: "user1"},
+		},
+		leges.Not{Residual: leges.Comparison{Field: []string{"object", "type"}, Operator: "in", Value: []interface{}{"draft"}}},
+	}
+
+	filter, err := leges.MongoFilter(residual)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"$and": []interface{}{
+			map[string]interface{}{
+				"$or": []interface{}{
+					map[string]interface{}{"owner.id": map[string]interface{}{"$eq": "user1"}},
+					map[string]interface{}{"members": map[string]interface{}{"$elemMatch": map[string]interface{}{"$eq": "user1"}}},
+				},
+			},
+			map[string]interface{}{
+				"$nor": []interface{}{
+					map[string]interface{}{"type": map[string]interface{}{"$in": []interface{}{"draft"}}},
+				},
+			},
+		},
+	}, filter)
+
+	filter, err = leges.MongoFilter(leges.Constant(false))
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"$expr": false}, filter)
+}
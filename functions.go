@@ -0,0 +1,53 @@
+package leges
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+)
+
+var ErrInvalidFunction = errors.New("invalid function")
+
+var functionName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedNames can't be used as function names, since leges puts them in
+// the environment of conditions itself
+var reservedNames = map[string]bool{
+	"subject": true,
+	"object":  true,
+	"env":     true,
+	"debug":   true,
+	"hasRole": true,
+	"related": true,
+}
+
+// WithFunctions registers functions that conditions can call by name, such as
+// {"cidrContains": func(cidr, ip string) bool {...}}. Each function must
+// return a single value. Conditions are type-checked against the parameters
+// and the result of the functions when they are loaded, so for example
+// cidrContains(1, 2) fails to load. A function may panic to make the
+// evaluation of a condition fail.
+//
+// Functions shadow the attributes of the environment with the same name. See
+// StandardLibrary for a set of common functions.
+func WithFunctions(functions map[string]interface{}) Option {
+	return func(l *Leges) error {
+		for name, fn := range functions {
+			if !functionName.MatchString(name) || reservedNames[name] {
+				return fmt.Errorf("name=%q: %w", name, ErrInvalidFunction)
+			}
+
+			t := reflect.TypeOf(fn)
+			if t == nil || t.Kind() != reflect.Func || t.NumOut() != 1 {
+				return fmt.Errorf("name=%q type=%T, expected a function returning one value: %w", name, fn, ErrInvalidFunction)
+			}
+
+			if l.functions == nil {
+				l.functions = map[string]interface{}{}
+			}
+			l.functions[name] = fn
+		}
+		return nil
+	}
+}
@@ -0,0 +1,94 @@
+package leges_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFunctions(t *testing.T) {
+	functions := map[string]interface{}{
+		"domainOf": func(email string) string {
+			return email[strings.Index(email, "@")+1:]
+		},
+		"mustBePositive": func(n int) bool {
+			if n <= 0 {
+				panic(errors.New("not positive"))
+			}
+			return true
+		},
+	}
+
+	t.Run("call registered functions", func(t *testing.T) {
+		rules, err := leges.NewLeges([]leges.Policy{
+			{
+				ID:        "same_domain",
+				Condition: `domainOf(subject.email) == domainOf(object.owner_email)`,
+				Actions:   []string{"VIEW"},
+			},
+		}, nil, leges.WithFunctions(functions))
+		require.NoError(t, err)
+
+		ok, _, err := rules.Match(leges.Request{
+			Action:  "VIEW",
+			Subject: leges.Attributes{"email": "alice@example.com"},
+			Object:  leges.Attributes{"owner_email": "bob@example.com"},
+		})
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+
+	t.Run("type-check calls when loading", func(t *testing.T) {
+		for _, condition := range []string{
+			`domainOf(true) == "example.com"`,
+			`domainOf("a@b", "c") == "b"`,
+			`mustBePositive("1")`,
+		} {
+			_, err := leges.NewLeges([]leges.Policy{
+				{ID: "policy1", Condition: condition, Actions: []string{"VIEW"}},
+			}, nil, leges.WithFunctions(functions))
+			var compileErr *leges.ErrExprCompileFailed
+			require.True(t, errors.As(err, &compileErr), condition)
+		}
+	})
+
+	t.Run("error if a condition returns a non-boolean function result", func(t *testing.T) {
+		_, err := leges.NewLeges([]leges.Policy{
+			{ID: "policy1", Condition: `domainOf(subject.email)`, Actions: []string{"VIEW"}},
+		}, nil, leges.WithFunctions(functions))
+		var nonBooleanErr *leges.ErrNonBooleanCondition
+		require.True(t, errors.As(err, &nonBooleanErr))
+		require.Equal(t, "string", nonBooleanErr.Type)
+	})
+
+	t.Run("panics fail the evaluation", func(t *testing.T) {
+		rules, err := leges.NewLeges([]leges.Policy{
+			{ID: "policy1", Condition: `mustBePositive(subject.level)`, Actions: []string{"VIEW"}},
+		}, nil, leges.WithFunctions(functions))
+		require.NoError(t, err)
+
+		_, _, err = rules.Match(leges.Request{
+			Action:  "VIEW",
+			Subject: leges.Attributes{"level": -1},
+			Object:  leges.Attributes{"type": "page"},
+		})
+		var runErr *leges.ErrExprRunFailed
+		require.True(t, errors.As(err, &runErr))
+		require.Contains(t, runErr.Err.Error(), "not positive")
+	})
+
+	t.Run("error on invalid functions", func(t *testing.T) {
+		for name, fn := range map[string]interface{}{
+			"subject":  func() bool { return true },
+			"not-name": func() bool { return true },
+			"noResult": func() {},
+			"notFunc":  "value",
+		} {
+			_, err := leges.NewLeges(nil, nil, leges.WithFunctions(map[string]interface{}{name: fn}))
+			require.True(t, errors.Is(err, leges.ErrInvalidFunction), name)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package leges
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidActionHierarchy = errors.New("invalid action hierarchy")
+
+// ActionHierarchy maps an action to the actions it implies. For example
+// {"MANAGE": {"VIEW", "UPDATE"}} makes allow policies of MANAGE apply to
+// requests of VIEW and UPDATE too, and deny policies of UPDATE apply to
+// requests of MANAGE, since managing includes updating. Implication is
+// transitive.
+type ActionHierarchy map[string][]string
+
+// WithActionHierarchy declares the actions that imply other actions, see
+// ActionHierarchy.
+func WithActionHierarchy(hierarchy ActionHierarchy) Option {
+	return func(l *Leges) error {
+		impliedBy := map[string][]string{}
+		for action, implied := range hierarchy {
+			if action == "" {
+				return fmt.Errorf("empty action: %w", ErrInvalidActionHierarchy)
+			}
+			for _, child := range implied {
+				if child == "" {
+					return fmt.Errorf("action=%q implies an empty action: %w", action, ErrInvalidActionHierarchy)
+				}
+				impliedBy[child] = append(impliedBy[child], action)
+			}
+		}
+
+		l.hierarchy = hierarchy
+		l.impliers = map[string][]string{}
+		for action := range impliedBy {
+			l.impliers[action] = closure(action, impliedBy)
+		}
+		l.implied = map[string][]string{}
+		for action := range hierarchy {
+			l.implied[action] = closure(action, hierarchy)
+		}
+		return nil
+	}
+}
+
+// closure returns action and every action reachable from it through edges
+func closure(action string, edges map[string][]string) []string {
+	seen := map[string]bool{action: true}
+	result := []string{action}
+	for i := 0; i < len(result); i++ {
+		for _, next := range edges[result[i]] {
+			if !seen[next] {
+				seen[next] = true
+				result = append(result, next)
+			}
+		}
+	}
+	return result
+}
+
+// requestedAction holds the actions that policies may list to apply to a
+// request of an action
+type requestedAction struct {
+	// allowing are the action and the actions implying it
+	allowing []string
+	// denying are the action and the actions it implies
+	denying []string
+}
+
+func (l *Leges) requestedAction(action string) requestedAction {
+	requested := requestedAction{
+		allowing: []string{action},
+		denying:  []string{action},
+	}
+	if impliers, ok := l.impliers[action]; ok {
+		requested.allowing = impliers
+	}
+	if implied, ok := l.implied[action]; ok {
+		requested.denying = implied
+	}
+	return requested
+}
+
+// appliesTo reports whether any of the action patterns of policy matches the
+// requested action, taking the action hierarchy into account
+func (r requestedAction) appliesTo(policy Policy) bool {
+	actions := r.allowing
+	if !policy.allows() {
+		actions = r.denying
+	}
+
+	for _, pattern := range policy.Actions {
+		for _, action := range actions {
+			if matchAction(pattern, action) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchAction reports whether action matches pattern, in which "*" matches
+// any sequence of characters. For example "page:*" matches "page:view".
+func matchAction(pattern, action string) bool {
+	if !strings.Contains(pattern, "*") {
+		return pattern == action
+	}
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(action, parts[0]) {
+		return false
+	}
+	action = action[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(action, part)
+		if i < 0 {
+			return false
+		}
+		action = action[i+len(part):]
+	}
+
+	return len(action) >= len(last) && strings.HasSuffix(action, last)
+}
+
+// actions returns the distinct concrete actions of all policies and the
+// action hierarchy, sorted by name. Patterns are not included, but the
+// concrete actions they match are.
+func (l *Leges) actions() []string {
+	seen := map[string]bool{}
+	var actions []string
+	add := func(action string) {
+		if !seen[action] && !strings.Contains(action, "*") {
+			seen[action] = true
+			actions = append(actions, action)
+		}
+	}
+
+	for _, statute := range l.cachedPolicies {
+		for _, action := range statute.policy.Actions {
+			add(action)
+		}
+	}
+	for action, implied := range l.hierarchy {
+		add(action)
+		for _, child := range implied {
+			add(child)
+		}
+	}
+
+	sort.Strings(actions)
+	return actions
+}
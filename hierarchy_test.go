@@ -0,0 +1,157 @@
+package leges_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActionPatterns(t *testing.T) {
+	rules := mustNewLeges(t, []leges.Policy{
+		{
+			ID:        "superuser_can_do_anything",
+			Condition: `subject.role == "superuser"`,
+			Actions:   []string{"*"},
+		},
+		{
+			ID:        "editor_can_do_anything_to_pages",
+			Condition: `subject.role == "editor"`,
+			Actions:   []string{"page:*"},
+		},
+		{
+			ID:        "reviewer_can_view_anything",
+			Condition: `subject.role == "reviewer"`,
+			Actions:   []string{"*:view"},
+		},
+	}, nil)
+
+	testCases := []struct {
+		role             string
+		action           string
+		expectedOk       bool
+		expectedPolicyID string
+	}{
+		{"superuser", "page:delete", true, "superuser_can_do_anything"},
+		{"superuser", "SIGNUP", true, "superuser_can_do_anything"},
+		{"editor", "page:update", true, "editor_can_do_anything_to_pages"},
+		{"editor", "page:", true, "editor_can_do_anything_to_pages"},
+		{"editor", "post:update", false, ""},
+		{"editor", "page", false, ""},
+		{"reviewer", "post:view", true, "reviewer_can_view_anything"},
+		{"reviewer", "post:viewer", false, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.role+" "+tc.action, func(t *testing.T) {
+			ok, policy, err := rules.Match(leges.Request{
+				Action:  tc.action,
+				Subject: leges.Attributes{"role": tc.role},
+				Object:  leges.Attributes{"type": "page"},
+			})
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOk, ok)
+			if tc.expectedOk {
+				require.Equal(t, tc.expectedPolicyID, policy.ID)
+			}
+		})
+	}
+}
+
+func TestActionHierarchy(t *testing.T) {
+	policies := []leges.Policy{
+		{
+			ID:        "owner_can_manage",
+			Condition: `object.owner_id == subject.id`,
+			Actions:   []string{"MANAGE"},
+		},
+		{
+			ID:        "members_can_comment",
+			Condition: `subject.id in object.members`,
+			Actions:   []string{"COMMENT"},
+		},
+		{
+			ID:        "nobody_can_update_locked",
+			Condition: `object.locked`,
+			Actions:   []string{"UPDATE"},
+			Effect:    leges.EffectDeny,
+		},
+	}
+
+	rules, err := leges.NewLeges(policies, nil, leges.WithActionHierarchy(leges.ActionHierarchy{
+		"MANAGE": {"UPDATE", "COMMENT"},
+		"UPDATE": {"VIEW"},
+	}))
+	require.NoError(t, err)
+
+	object := leges.Attributes{"owner_id": "user1", "members": []interface{}{"user2"}, "locked": false}
+
+	t.Run("implied actions are granted", func(t *testing.T) {
+		for _, action := range []string{"MANAGE", "UPDATE", "COMMENT", "VIEW"} {
+			ok, policy, err := rules.Match(leges.Request{
+				Action:  action,
+				Subject: leges.Attributes{"id": "user1"},
+				Object:  object,
+			})
+			require.NoError(t, err)
+			require.True(t, ok, action)
+			require.Equal(t, "owner_can_manage", policy.ID)
+		}
+	})
+
+	t.Run("implying actions are not granted", func(t *testing.T) {
+		ok, _, err := rules.Match(leges.Request{
+			Action:  "MANAGE",
+			Subject: leges.Attributes{"id": "user2"},
+			Object:  object,
+		})
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("denying an implied action denies the implying actions", func(t *testing.T) {
+		lockedObject := leges.Attributes{"owner_id": "user1", "locked": true}
+
+		actions, err := rules.AllowedActions(leges.Attributes{"id": "user1"}, lockedObject)
+		require.NoError(t, err)
+		require.Equal(t, []leges.AllowedAction{
+			{Action: "COMMENT", PolicyIDs: []string{"owner_can_manage"}},
+			{Action: "VIEW", PolicyIDs: []string{"owner_can_manage"}},
+		}, actions)
+	})
+
+	t.Run("allowed actions are concrete", func(t *testing.T) {
+		actions, err := rules.AllowedActions(leges.Attributes{"id": "user1"}, object)
+		require.NoError(t, err)
+		require.Equal(t, []leges.AllowedAction{
+			{Action: "COMMENT", PolicyIDs: []string{"owner_can_manage"}},
+			{Action: "MANAGE", PolicyIDs: []string{"owner_can_manage"}},
+			{Action: "UPDATE", PolicyIDs: []string{"owner_can_manage"}},
+			{Action: "VIEW", PolicyIDs: []string{"owner_can_manage"}},
+		}, actions)
+	})
+
+	t.Run("cycles are allowed", func(t *testing.T) {
+		rules, err := leges.NewLeges(policies, nil, leges.WithActionHierarchy(leges.ActionHierarchy{
+			"MANAGE": {"VIEW"},
+			"VIEW":   {"MANAGE"},
+		}))
+		require.NoError(t, err)
+
+		ok, _, err := rules.Match(leges.Request{
+			Action:  "VIEW",
+			Subject: leges.Attributes{"id": "user1"},
+			Object:  object,
+		})
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+
+	t.Run("error on empty action", func(t *testing.T) {
+		_, err := leges.NewLeges(policies, nil, leges.WithActionHierarchy(leges.ActionHierarchy{
+			"MANAGE": {""},
+		}))
+		require.True(t, errors.Is(err, leges.ErrInvalidActionHierarchy))
+	})
+}
@@ -2,11 +2,18 @@ package httpserver
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/siadat/leges"
 	"gopkg.in/yaml.v2"
@@ -14,65 +21,595 @@ import (
 
 type Response map[string]interface{}
 
+const (
+	// DefaultMaxBodyBytes is the default of Server.MaxBodyBytes
+	DefaultMaxBodyBytes = 1 << 20
+	// DefaultMaxBatchSize is the default of Server.MaxBatchSize
+	DefaultMaxBatchSize = 1000
+)
+
+var errRequestTooLarge = errors.New("request too large")
+
+// RequestIDHeader is the header of the correlation ID of a request. If a
+// request doesn't have it, a random ID is generated. The ID is sent back in
+// the response and attached to the debug events of the request.
+const RequestIDHeader = "X-Request-Id"
+
+// Server serves leges over HTTP. The policies are compiled once, on the first
+// request or by SetPolicies, and the compiled policies are shared by all
+// requests.
 type Server struct {
+	// Policies are the policies compiled on the first request, unless
+	// SetPolicies is called before. Changing it afterwards has no effect,
+	// use SetPolicies instead.
 	Policies []leges.Policy
+	// CombiningAlgorithm is passed to leges.NewLeges, the default is
+	// leges.DenyOverrides
+	CombiningAlgorithm leges.CombiningAlgorithm
+	// FailureMode is passed to leges.WithFailureMode, the default is
+	// leges.FailClosed
+	FailureMode leges.FailureMode
+	// EvaluationTimeout limits the evaluation of each condition, zero means
+	// no limit
+	EvaluationTimeout time.Duration
+	// Schema is used to type-check the conditions of Policies, nil means no
+	// type-checking
+	Schema *leges.Schema
+	// ActionHierarchy declares the actions that imply other actions, nil
+	// means actions only imply themselves
+	ActionHierarchy leges.ActionHierarchy
+	// Roles are passed to leges.WithRoles, their permissions are served
+	// along with Policies
+	Roles []leges.Role
+	// Functions are passed to leges.WithFunctions
+	Functions map[string]interface{}
+	// Tracer receives the values conditions pass to debug(), nil means they
+	// are dropped
+	Tracer leges.Tracer
+	// FillEnvironment adds the time of the request, as an RFC 3339 string,
+	// and the IP address of the client to the environment of every request
+	// as env.time and env.ip, unless the request sets them
+	FillEnvironment bool
+	// MaxBodyBytes limits the size of the body of requests, larger requests
+	// fail with status 413. Zero means DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+	// MaxBatchSize limits the number of requests of a batch, larger batches
+	// fail with status 413. Zero means DefaultMaxBatchSize.
+	MaxBatchSize int
+
+	// rules holds the compiled *leges.Leges, it is replaced as a whole when
+	// the policies change
+	rules atomic.Value
+	// mu serializes compiling the policies and guards status and loadErr
+	mu     sync.Mutex
+	status Status
+	// loadErr is the error of compiling Policies on the first request, it
+	// is returned to every request until SetPolicies succeeds
+	loadErr error
+}
+
+// Status describes the policies currently served by a Server
+type Status struct {
+	// Revision is incremented every time the policies are replaced, it is
+	// zero until the policies are compiled for the first time
+	Revision int `json:"revision"`
+	// LoadedAt is when the current policies were compiled
+	LoadedAt time.Time `json:"loaded_at"`
+	// LastReloadError is the error of the last attempt to replace the
+	// policies, it is empty if the last attempt succeeded
+	LastReloadError string `json:"last_reload_error,omitempty"`
+}
+
+// SetPolicies compiles policies and atomically replaces the policies of the
+// server with them. In-flight requests finish with the previous policies. If
+// compiling fails, the previous policies are kept. Concurrent calls are
+// serialized, so the policies of the last call are the ones served.
+func (srv *Server) SetPolicies(policies []leges.Policy) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	rules, err := leges.NewLeges(policies, nil, srv.options()...)
+	if err != nil {
+		srv.status.LastReloadError = err.Error()
+		return err
+	}
+
+	srv.store(rules)
+	return nil
+}
+
+// LoadPolicies reads policies from YAML and replaces the policies of the
+// server with them, see SetPolicies.
+func (srv *Server) LoadPolicies(y io.Reader) error {
+	policies, err := LoadPoliciesFromYaml(y)
+	if err != nil {
+		srv.mu.Lock()
+		srv.status.LastReloadError = err.Error()
+		srv.mu.Unlock()
+		return err
+	}
+
+	return srv.SetPolicies(policies)
+}
+
+// Status returns the status of the policies
+func (srv *Server) Status() Status {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.status
+}
+
+// store replaces the compiled policies, srv.mu must be held
+func (srv *Server) store(rules *leges.Leges) {
+	srv.rules.Store(rules)
+	srv.loadErr = nil
+	srv.status = Status{
+		Revision: srv.status.Revision + 1,
+		LoadedAt: time.Now(),
+	}
+}
+
+// leges returns the compiled policies, compiling Policies on the first call.
+// If compiling fails, the error is recorded in the status and returned by the
+// following calls as well, without compiling Policies again.
+func (srv *Server) leges() (*leges.Leges, error) {
+	if rules, ok := srv.rules.Load().(*leges.Leges); ok {
+		return rules, nil
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if rules, ok := srv.rules.Load().(*leges.Leges); ok {
+		return rules, nil
+	}
+	if srv.loadErr != nil {
+		return nil, srv.loadErr
+	}
+
+	rules, err := leges.NewLeges(srv.Policies, nil, srv.options()...)
+	if err != nil {
+		srv.loadErr = err
+		srv.status.LastReloadError = err.Error()
+		return nil, err
+	}
+	srv.store(rules)
+	return rules, nil
+}
+
+func (srv *Server) options() []leges.Option {
+	var opts []leges.Option
+	if srv.CombiningAlgorithm != "" {
+		opts = append(opts, leges.WithCombiningAlgorithm(srv.CombiningAlgorithm))
+	}
+	if srv.FailureMode != "" {
+		opts = append(opts, leges.WithFailureMode(srv.FailureMode))
+	}
+	if srv.EvaluationTimeout > 0 {
+		opts = append(opts, leges.WithEvaluationTimeout(srv.EvaluationTimeout))
+	}
+	if srv.Schema != nil {
+		opts = append(opts, leges.WithSchema(srv.Schema))
+	}
+	if srv.ActionHierarchy != nil {
+		opts = append(opts, leges.WithActionHierarchy(srv.ActionHierarchy))
+	}
+	if srv.Roles != nil {
+		opts = append(opts, leges.WithRoles(srv.Roles))
+	}
+	if srv.Functions != nil {
+		opts = append(opts, leges.WithFunctions(srv.Functions))
+	}
+	if srv.Tracer != nil {
+		opts = append(opts, leges.WithTracer(srv.Tracer))
+	}
+	return opts
 }
 
 func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Printf("%s %s", r.Method, r.URL.String())
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" {
+		id = newRequestID()
+	}
+	w.Header().Set(RequestIDHeader, id)
+	r = r.WithContext(leges.WithCorrelationID(r.Context(), id))
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, srv.maxBodyBytes())
+	}
+
+	log.Printf("%s %s %s=%s", r.Method, r.URL.String(), RequestIDHeader, id)
+
+	switch r.URL.Path {
+	case "/status":
+		srv.serveStatus(w)
+	case "/match/batch":
+		srv.serveBatch(w, r)
+	case "/allowed-actions":
+		srv.serveAllowedActions(w, r)
+	case "/redact":
+		srv.serveRedact(w, r)
+	default:
+		srv.serveMatch(w, r)
+	}
+}
+
+// newRequestID returns a random ID for requests without a RequestIDHeader
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func (srv *Server) serveStatus(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, srv.Status())
+}
+
+// MatchRequest is a request to the match endpoint. It is read from the JSON
+// body of POST requests, and from the query of GET requests, where subject
+// and object are URL-encoded JSON values.
+type MatchRequest struct {
+	Subject leges.Attributes `json:"subject"`
+	Object  leges.Attributes `json:"object"`
+	Action  string           `json:"action"`
+	// Environment is available to conditions as env.*
+	Environment leges.Attributes `json:"environment"`
+	// Explain adds the evaluation trace of every policy to the response
+	Explain bool `json:"explain"`
+}
+
+func (req MatchRequest) request() leges.Request {
+	return leges.Request{
+		Object:      req.Object,
+		Subject:     req.Subject,
+		Action:      req.Action,
+		Environment: req.Environment,
+	}
+}
+
+// fillEnvironment adds env.time and env.ip to the environment of a request,
+// if srv.FillEnvironment is set
+func (srv *Server) fillEnvironment(r *http.Request, now time.Time, env leges.Attributes) leges.Attributes {
+	if !srv.FillEnvironment {
+		return env
+	}
+
+	if env == nil {
+		env = leges.Attributes{}
+	}
+	if _, ok := env["time"]; !ok {
+		env["time"] = now.UTC().Format(time.RFC3339)
+	}
+	if _, ok := env["ip"]; !ok {
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			env["ip"] = host
+		}
+	}
+	return env
+}
+
+func (srv *Server) maxBodyBytes() int64 {
+	if srv.MaxBodyBytes > 0 {
+		return srv.MaxBodyBytes
+	}
+	return DefaultMaxBodyBytes
+}
+
+func (srv *Server) maxBatchSize() int {
+	if srv.MaxBatchSize > 0 {
+		return srv.MaxBatchSize
+	}
+	return DefaultMaxBatchSize
+}
+
+// readBody decodes the JSON body of r, whose size is limited by
+// http.MaxBytesReader
+func readBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		// http.MaxBytesReader fails with an untyped error
+		if err.Error() == "http: request body too large" {
+			return fmt.Errorf("body is too large: %w", errRequestTooLarge)
+		}
+		return fmt.Errorf("JSON parse error: body must be valid JSON: %s", err.Error())
+	}
+	return nil
+}
+
+// readErrorStatus returns the status of a request that failed to be read
+func readErrorStatus(err error) int {
+	if errors.Is(err, errRequestTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// readMatchRequest reads a MatchRequest from the body or the query of r,
+// depending on the method of r
+func readMatchRequest(r *http.Request) (MatchRequest, error) {
+	var req MatchRequest
+
+	if r.Method == http.MethodPost {
+		return req, readBody(r, &req)
+	}
 
 	objectAttributes, err := UnmarshalAttributes(r.URL.Query().Get("object"))
 	if err != nil {
-		fmt.Fprint(w, MustMarshal(Response{
-			"error": fmt.Sprintf("JSON parse error: 'object' must be valid JSON: %s", err.Error()),
-		}))
-		return
+		return req, fmt.Errorf("JSON parse error: 'object' must be valid JSON: %s", err.Error())
 	}
 
 	subjectAttributes, err := UnmarshalAttributes(r.URL.Query().Get("subject"))
 	if err != nil {
-		fmt.Fprint(w, MustMarshal(Response{
-			"error": fmt.Sprintf("JSON parse error: 'subject' must be valid JSON: %s", err.Error()),
-		}))
+		return req, fmt.Errorf("JSON parse error: 'subject' must be valid JSON: %s", err.Error())
+	}
+
+	if environment := r.URL.Query().Get("environment"); environment != "" {
+		req.Environment, err = UnmarshalAttributes(environment)
+		if err != nil {
+			return req, fmt.Errorf("JSON parse error: 'environment' must be valid JSON: %s", err.Error())
+		}
+	}
+
+	req.Object = objectAttributes
+	req.Subject = subjectAttributes
+	req.Action = r.URL.Query().Get("action")
+	req.Explain = r.URL.Query().Get("explain") == "true"
+	return req, nil
+}
+
+func (srv *Server) serveMatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
 		return
 	}
 
-	request := leges.Request{
-		Object:  objectAttributes,
-		Subject: subjectAttributes,
-		Action:  r.URL.Query().Get("action"),
+	req, err := readMatchRequest(r)
+	if err != nil {
+		writeError(w, readErrorStatus(err), err)
+		return
 	}
+	req.Environment = srv.fillEnvironment(r, time.Now(), req.Environment)
 
-	rules, err := leges.NewLeges(srv.Policies, nil)
+	request := req.request()
+	if err := request.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rules, err := srv.leges()
 	if err != nil {
-		fmt.Fprint(w, MustMarshal(Response{
-			"error": err.Error(),
-		}))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if req.Explain {
+		srv.serveExplanation(w, r, rules, request)
+		return
+	}
+
+	decision := rules.DecideContext(r.Context(), request)
+
+	if decision.Verdict == leges.Indeterminate {
+		writeError(w, http.StatusInternalServerError, decision.Errors[len(decision.Errors)-1])
 		return
 	}
 
-	ok, policy, err := rules.Match(request)
+	response := Response{
+		"match": decision.Allowed(),
+	}
+	if decision.Policy != nil {
+		response["id"] = decision.Policy.ID
+	}
+	addObligations(response, decision.Obligations, decision.Advice)
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// addObligations adds the obligations and the advice of a decision to a
+// response, if there are any
+func addObligations(response Response, obligations, advice map[string]interface{}) {
+	if obligations != nil {
+		response["obligations"] = obligations
+	}
+	if advice != nil {
+		response["advice"] = advice
+	}
+}
+
+// BatchRequest is the JSON body of a request to the batch endpoint. Subject,
+// Action and Environment are used for the requests that don't set their own.
+// Requests that use the shared subject and environment are evaluated faster.
+type BatchRequest struct {
+	Subject     leges.Attributes `json:"subject"`
+	Action      string           `json:"action"`
+	Environment leges.Attributes `json:"environment"`
+	Requests    []MatchRequest   `json:"requests"`
+}
+
+func (srv *Server) serveBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
 
+	var batch BatchRequest
+	if err := readBody(r, &batch); err != nil {
+		writeError(w, readErrorStatus(err), err)
+		return
+	}
+	if len(batch.Requests) > srv.maxBatchSize() {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("batch has %d requests, at most %d are allowed: %w", len(batch.Requests), srv.maxBatchSize(), errRequestTooLarge))
+		return
+	}
+
+	now := time.Now()
+	batch.Environment = srv.fillEnvironment(r, now, batch.Environment)
+
+	requests := make([]leges.Request, len(batch.Requests))
+	for i, req := range batch.Requests {
+		if req.Subject == nil {
+			req.Subject = batch.Subject
+		}
+		if req.Action == "" {
+			req.Action = batch.Action
+		}
+		if req.Environment == nil {
+			req.Environment = batch.Environment
+		} else {
+			req.Environment = srv.fillEnvironment(r, now, req.Environment)
+		}
+		requests[i] = req.request()
+	}
+
+	rules, err := srv.leges()
 	if err != nil {
-		fmt.Fprint(w, MustMarshal(Response{
-			"error": err.Error(),
-		}))
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if policy != nil {
-		fmt.Fprint(w, MustMarshal(Response{
-			"id":    policy.ID,
-			"match": ok,
-		}))
+	results := make([]Response, len(requests))
+	for i, result := range rules.MatchBatchContext(r.Context(), requests) {
+		switch {
+		case result.Err != nil:
+			results[i] = Response{"error": result.Err.Error()}
+		case result.Policy != nil:
+			results[i] = Response{"match": result.Match, "id": result.Policy.ID}
+		default:
+			results[i] = Response{"match": result.Match}
+		}
+		addObligations(results[i], result.Obligations, result.Advice)
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		"results": results,
+	})
+}
+
+// serveAllowedActions responds with the actions the subject may perform on
+// the object, read like the subject and the object of the match endpoint
+func (srv *Server) serveAllowedActions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	req, err := readMatchRequest(r)
+	if err != nil {
+		writeError(w, readErrorStatus(err), err)
+		return
+	}
+
+	rules, err := srv.leges()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	req.Environment = srv.fillEnvironment(r, time.Now(), req.Environment)
+
+	actions, err := rules.AllowedActionsContext(r.Context(), req.request())
+	switch err {
+	case nil:
+	case leges.ErrEmptySubjectAttrs, leges.ErrEmptyObjectAttrs:
+		writeError(w, http.StatusBadRequest, err)
+		return
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		"actions": actions,
+	})
+}
+
+// serveRedact responds with the object of a match request without the
+// fields the subject may not see
+func (srv *Server) serveRedact(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	req, err := readMatchRequest(r)
+	if err != nil {
+		writeError(w, readErrorStatus(err), err)
+		return
+	}
+	req.Environment = srv.fillEnvironment(r, time.Now(), req.Environment)
+
+	request := req.request()
+	if err := request.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	{
-		fmt.Fprint(w, MustMarshal(Response{
-			"match": ok,
+	rules, err := srv.leges()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	object, err := rules.FilterAttributesContext(r.Context(), request, req.Object)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		"object": object,
+	})
+}
+
+// serveExplanation responds with the result of a match along with the
+// evaluation trace of every policy
+func (srv *Server) serveExplanation(w http.ResponseWriter, r *http.Request, rules *leges.Leges, request leges.Request) {
+	explanation, err := rules.ExplainContext(r.Context(), request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if explanation.Err != nil {
+		writeJSON(w, http.StatusInternalServerError, Response{
+			"error":   explanation.Err.Error(),
+			"explain": explanation.Policies,
+		})
+		return
+	}
+
+	response := Response{
+		"match":   explanation.Match,
+		"explain": explanation.Policies,
+	}
+	if explanation.Policy != nil {
+		response["id"] = explanation.Policy.ID
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// writeJSON responds with whatever encoded as JSON. Values that can't be
+// encoded, such as an obligation evaluating to +Inf, fail with status 500.
+func writeJSON(w http.ResponseWriter, status int, whatever interface{}) {
+	buf := bytes.NewBuffer(nil)
+	if err := json.NewEncoder(buf).Encode(whatever); err != nil {
+		status = http.StatusInternalServerError
+		buf.Reset()
+		fmt.Fprint(buf, MustMarshal(Response{
+			"error": fmt.Sprintf("failed to encode the response: %s", err.Error()),
 		}))
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, Response{
+		"error": err.Error(),
+	})
 }
 
 func LoadPoliciesFromYaml(y io.Reader) ([]leges.Policy, error) {
@@ -82,9 +619,92 @@ func LoadPoliciesFromYaml(y io.Reader) ([]leges.Policy, error) {
 	if err != nil {
 		return nil, err
 	}
+	for _, policy := range policies {
+		for _, obligations := range [][]leges.Obligation{policy.Obligations, policy.Advice} {
+			for i := range obligations {
+				obligations[i].Value = fromYaml(obligations[i].Value)
+			}
+		}
+	}
 	return policies, nil
 }
 
+// fromYaml converts the mappings YAML decodes to map[interface{}]interface{}
+// to map[string]interface{}, so that they can be encoded as JSON
+func fromYaml(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = fromYaml(value)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = fromYaml(v[i])
+		}
+	}
+	return value
+}
+
+// LoadSchemaFromYaml reads a leges.Schema from YAML. Since JSON is valid YAML,
+// it also reads JSON Schema documents, whose root is an object with the
+// subject, object and environment properties. A schema without any of them
+// fails with leges.ErrInvalidSchema.
+func LoadSchemaFromYaml(y io.Reader) (*leges.Schema, error) {
+	decoder := yaml.NewDecoder(y)
+	var document struct {
+		leges.Schema `yaml:",inline"`
+		// Properties are the properties of a JSON Schema root document
+		Properties map[string]*leges.AttributeSchema `yaml:"properties"`
+	}
+	err := decoder.Decode(&document)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := document.Schema
+	for name, property := range document.Properties {
+		switch name {
+		case "subject":
+			schema.Subject = property
+		case "object":
+			schema.Object = property
+		case "environment":
+			schema.Environment = property
+		default:
+			return nil, fmt.Errorf("unknown property %q, expected subject, object or environment: %w", name, leges.ErrInvalidSchema)
+		}
+	}
+	if schema.Subject == nil && schema.Object == nil && schema.Environment == nil {
+		return nil, fmt.Errorf("no subject, object or environment: %w", leges.ErrInvalidSchema)
+	}
+	return &schema, nil
+}
+
+// LoadActionHierarchyFromYaml reads a leges.ActionHierarchy from YAML, a
+// mapping from each action to the list of actions it implies.
+func LoadActionHierarchyFromYaml(y io.Reader) (leges.ActionHierarchy, error) {
+	decoder := yaml.NewDecoder(y)
+	var hierarchy leges.ActionHierarchy
+	err := decoder.Decode(&hierarchy)
+	if err != nil {
+		return nil, err
+	}
+	return hierarchy, nil
+}
+
+// LoadRolesFromYaml reads a list of leges.Role from YAML.
+func LoadRolesFromYaml(y io.Reader) ([]leges.Role, error) {
+	decoder := yaml.NewDecoder(y)
+	var roles []leges.Role
+	err := decoder.Decode(&roles)
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
 func UnmarshalAttributes(jsonified string) (leges.Attributes, error) {
 	var attributes leges.Attributes
 	buf := bytes.NewBufferString(jsonified)
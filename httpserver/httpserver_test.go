@@ -2,10 +2,17 @@ package httpserver_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/siadat/leges"
@@ -25,6 +32,11 @@ func TestServer(t *testing.T) {
 			Condition: "object.k == subject.k",
 			Actions:   []string{"ACTION1"},
 		},
+		{
+			ID:        "policy2",
+			Condition: "subject.k",
+			Actions:   []string{"ACTION3"},
+		},
 	}})
 	defer srv.Close()
 
@@ -32,38 +44,51 @@ func TestServer(t *testing.T) {
 		subject        string
 		object         string
 		action         string
+		expectedStatus int
 		expectedResult string
 	}{
 		{
 			subject:        httpserver.MustMarshal(leges.Attributes{"m": "1", "n": "2"}),
 			object:         httpserver.MustMarshal(leges.Attributes{"m": "1", "n": "2"}),
 			action:         "ACTION0",
+			expectedStatus: http.StatusOK,
 			expectedResult: `{"match": true, "id": "policy0"}`,
 		},
 		{
 			subject:        httpserver.MustMarshal(leges.Attributes{"k": "v"}),
 			object:         httpserver.MustMarshal(leges.Attributes{"k": "v"}),
 			action:         "ACTION1",
+			expectedStatus: http.StatusOK,
 			expectedResult: `{"match": true, "id": "policy1"}`,
 		},
 		{
 			subject:        "",
 			object:         httpserver.MustMarshal(leges.Attributes{"k": "v"}),
 			action:         "ACTION1",
+			expectedStatus: http.StatusBadRequest,
 			expectedResult: `{"error": "JSON parse error: 'subject' must be valid JSON: EOF"}`,
 		},
 		{
 			subject:        httpserver.MustMarshal(leges.Attributes{"k": "v"}),
 			object:         "",
 			action:         "ACTION1",
+			expectedStatus: http.StatusBadRequest,
 			expectedResult: `{"error": "JSON parse error: 'object' must be valid JSON: EOF"}`,
 		},
 		{
 			subject:        httpserver.MustMarshal(leges.Attributes{"k": "v"}),
 			object:         httpserver.MustMarshal(leges.Attributes{"k": "v"}),
 			action:         "ACTION2",
+			expectedStatus: http.StatusOK,
 			expectedResult: `{"match": false}`,
 		},
+		{
+			subject:        httpserver.MustMarshal(leges.Attributes{"k": "v"}),
+			object:         httpserver.MustMarshal(leges.Attributes{"k": "v"}),
+			action:         "ACTION3",
+			expectedStatus: http.StatusInternalServerError,
+			expectedResult: `{"error": "condition of policy \"policy2\" returns string, expected bool"}`,
+		},
 	}
 
 	for _, tt := range testCases {
@@ -82,7 +107,693 @@ func TestServer(t *testing.T) {
 		resBody, err := ioutil.ReadAll(res.Body)
 		require.NoError(t, err)
 		require.JSONEq(t, tt.expectedResult, string(resBody))
+		require.Equal(t, tt.expectedStatus, res.StatusCode)
+		require.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	}
+}
+
+func TestServerPost(t *testing.T) {
+	srv := httptest.NewServer(&httpserver.Server{Policies: []leges.Policy{
+		{
+			ID:        "policy0",
+			Condition: "object.k == subject.k",
+			Actions:   []string{"ACTION0"},
+		},
+	}})
+	defer srv.Close()
+
+	testCases := []struct {
+		body           string
+		expectedStatus int
+		expectedResult string
+	}{
+		{
+			body:           `{"subject": {"k": "v"}, "object": {"k": "v"}, "action": "ACTION0"}`,
+			expectedStatus: http.StatusOK,
+			expectedResult: `{"match": true, "id": "policy0"}`,
+		},
+		{
+			body:           `{"subject": {"k": "v"}, "object": {"k": "w"}, "action": "ACTION0"}`,
+			expectedStatus: http.StatusOK,
+			expectedResult: `{"match": false}`,
+		},
+		{
+			body:           `{"subject": {"k": "v"}, "object": {"k": "v"}, "action": "ACTION0", "explain": true}`,
+			expectedStatus: http.StatusOK,
+			expectedResult: `{"match": true, "id": "policy0", "explain": [{"id": "policy0", "status": "true"}]}`,
+		},
+		{
+			body:           `{"subject": {"k": "v"}, "object": {"k": "v"}}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedResult: `{"error": "action is empty"}`,
+		},
+		{
+			body:           `{"subject": {"k": "v"`,
+			expectedStatus: http.StatusBadRequest,
+			expectedResult: `{"error": "JSON parse error: body must be valid JSON: unexpected EOF"}`,
+		},
+	}
+
+	for _, tt := range testCases {
+		res, err := http.Post(srv.URL+"/match", "application/json", bytes.NewBufferString(tt.body))
+		require.NoError(t, err)
+
+		resBody, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.JSONEq(t, tt.expectedResult, string(resBody))
+		require.Equal(t, tt.expectedStatus, res.StatusCode)
+		require.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	}
+
+	req, err := http.NewRequest("DELETE", srv.URL+"/match", nil)
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+}
+
+func TestServerExplain(t *testing.T) {
+	srv := httptest.NewServer(&httpserver.Server{Policies: []leges.Policy{
+		{
+			ID:        "policy0",
+			Condition: `subject.role == "admin" and object.type == "page"`,
+			Actions:   []string{"UPDATE"},
+		},
+		{
+			ID:        "policy1",
+			Condition: "true",
+			Actions:   []string{"VIEW"},
+		},
+	}})
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	require.NoError(t, err)
+
+	params := req.URL.Query()
+	params.Set("subject", httpserver.MustMarshal(leges.Attributes{"role": "guest"}))
+	params.Set("object", httpserver.MustMarshal(leges.Attributes{"type": "page"}))
+	params.Set("action", "UPDATE")
+	params.Set("explain", "true")
+	req.URL.RawQuery = params.Encode()
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"match": false,
+		"explain": [
+			{
+				"id": "policy0",
+				"status": "false",
+				"false_expressions": [
+					{
+						"expression": "subject.role == \"admin\"",
+						"values": {"subject.role": "guest"}
+					}
+				]
+			},
+			{
+				"id": "policy1",
+				"status": "skipped"
+			}
+		]
+	}`, string(resBody))
+}
+
+func TestServerBatch(t *testing.T) {
+	srv := httptest.NewServer(&httpserver.Server{Policies: []leges.Policy{
+		{
+			ID:        "policy0",
+			Condition: "object.owner_id == subject.id",
+			Actions:   []string{"VIEW"},
+		},
+		{
+			ID:        "policy1",
+			Condition: `subject.role == "admin"`,
+			Actions:   []string{"VIEW"},
+		},
+	}})
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/match/batch", "application/json", bytes.NewBufferString(`{
+		"subject": {"id": "user1"},
+		"action": "VIEW",
+		"requests": [
+			{"object": {"owner_id": "user1"}},
+			{"object": {"owner_id": "user2"}},
+			{"object": {"owner_id": "user2"}, "subject": {"role": "admin"}},
+			{"object": {"owner_id": "user1"}, "action": "UPDATE"},
+			{"object": {}}
+		]
+	}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"results": [
+		{"match": true, "id": "policy0"},
+		{"match": false},
+		{"match": true, "id": "policy1"},
+		{"match": false},
+		{"error": "object attributes is empty"}
+	]}`, string(resBody))
+
+	res, err = http.Post(srv.URL+"/match/batch", "application/json", bytes.NewBufferString(`{"requests": [`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res, err = http.Get(srv.URL + "/match/batch")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+}
+
+func TestServerAllowedActions(t *testing.T) {
+	srv := httptest.NewServer(&httpserver.Server{Policies: []leges.Policy{
+		{
+			ID:        "policy0",
+			Condition: "object.owner_id == subject.id",
+			Actions:   []string{"VIEW", "UPDATE"},
+		},
+		{
+			ID:        "policy1",
+			Condition: `subject.role == "admin"`,
+			Actions:   []string{"VIEW", "DELETE"},
+		},
+	}})
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/allowed-actions", "application/json", bytes.NewBufferString(`{
+		"subject": {"id": "user1", "role": "admin"},
+		"object": {"owner_id": "user1"}
+	}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"actions": [
+		{"action": "DELETE", "ids": ["policy1"]},
+		{"action": "UPDATE", "ids": ["policy0"]},
+		{"action": "VIEW", "ids": ["policy0", "policy1"]}
+	]}`, string(resBody))
+
+	res, err = http.Post(srv.URL+"/allowed-actions", "application/json", bytes.NewBufferString(`{
+		"subject": {"id": "user1"}
+	}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestServerFunctions(t *testing.T) {
+	handler := &httpserver.Server{
+		Policies: []leges.Policy{
+			{
+				ID:        "internal_can_view",
+				Condition: `cidrContains("10.0.0.0/8", subject.ip)`,
+				Actions:   []string{"VIEW"},
+			},
+		},
+		Functions: leges.StandardLibrary(),
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/match", bytes.NewBufferString(`{
+		"subject": {"ip": "10.1.2.3"},
+		"object": {"type": "page"},
+		"action": "VIEW"
+	}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"match": true, "id": "internal_can_view"}`, w.Body.String())
+}
+
+func TestServerRequestID(t *testing.T) {
+	var events []leges.DebugEvent
+	handler := &httpserver.Server{
+		Policies: []leges.Policy{
+			{
+				ID:        "admins_can_view",
+				Condition: `debug(subject.role) and subject.role == "admin"`,
+				Actions:   []string{"VIEW"},
+			},
+		},
+		Tracer: leges.TracerFunc(func(ctx context.Context, event leges.DebugEvent) {
+			events = append(events, event)
+		}),
+	}
+	body := `{"subject": {"role": "guest"}, "object": {"type": "page"}, "action": "VIEW"}`
+
+	req := httptest.NewRequest("POST", "/match", bytes.NewBufferString(body))
+	req.Header.Set(httpserver.RequestIDHeader, "request-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "request-1", w.Header().Get(httpserver.RequestIDHeader))
+	require.Equal(t, []leges.DebugEvent{{CorrelationID: "request-1", Value: "guest"}}, events)
+
+	// requests without an ID are given one
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/match", bytes.NewBufferString(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+	id := w.Header().Get(httpserver.RequestIDHeader)
+	require.NotEmpty(t, id)
+	require.Len(t, events, 2)
+	require.Equal(t, id, events[1].CorrelationID)
+}
+
+func TestServerEnvironment(t *testing.T) {
+	handler := &httpserver.Server{
+		Policies: []leges.Policy{
+			{
+				ID:        "mfa_can_view",
+				Condition: `env.mfa == true`,
+				Actions:   []string{"VIEW"},
+			},
+			{
+				ID:        "local_can_update",
+				Condition: `env.ip == "192.0.2.1" and timeAfter(env.time, "2020-01-01T00:00:00Z")`,
+				Actions:   []string{"UPDATE"},
+			},
+		},
+		Functions:       leges.StandardLibrary(),
+		FillEnvironment: true,
+	}
+
+	for _, tt := range []struct {
+		name     string
+		request  *http.Request
+		expected string
+	}{
+		{
+			name: "environment in the body",
+			request: httptest.NewRequest("POST", "/match", bytes.NewBufferString(`{
+				"subject": {"id": "user1"},
+				"object": {"type": "page"},
+				"action": "VIEW",
+				"environment": {"mfa": true}
+			}`)),
+			expected: `{"match": true, "id": "mfa_can_view"}`,
+		},
+		{
+			name: "environment in the query",
+			request: httptest.NewRequest("GET", "/match?"+url.Values{
+				"subject":     {`{"id": "user1"}`},
+				"object":      {`{"type": "page"}`},
+				"action":      {"VIEW"},
+				"environment": {`{"mfa": true}`},
+			}.Encode(), nil),
+			expected: `{"match": true, "id": "mfa_can_view"}`,
+		},
+		{
+			name: "filled environment",
+			request: httptest.NewRequest("POST", "/match", bytes.NewBufferString(`{
+				"subject": {"id": "user1"},
+				"object": {"type": "page"},
+				"action": "UPDATE"
+			}`)),
+			expected: `{"match": true, "id": "local_can_update"}`,
+		},
+		{
+			name: "the request environment takes precedence over the filled environment",
+			request: httptest.NewRequest("POST", "/match", bytes.NewBufferString(`{
+				"subject": {"id": "user1"},
+				"object": {"type": "page"},
+				"action": "UPDATE",
+				"environment": {"ip": "198.51.100.1"}
+			}`)),
+			expected: `{"match": false}`,
+		},
+		{
+			name: "environment of allowed actions",
+			request: httptest.NewRequest("POST", "/allowed-actions", bytes.NewBufferString(`{
+				"subject": {"id": "user1"},
+				"object": {"type": "page"},
+				"environment": {"mfa": true}
+			}`)),
+			expected: `{"actions": [
+				{"action": "UPDATE", "ids": ["local_can_update"]},
+				{"action": "VIEW", "ids": ["mfa_can_view"]}
+			]}`,
+		},
+		{
+			name: "batch environment",
+			request: httptest.NewRequest("POST", "/match/batch", bytes.NewBufferString(`{
+				"subject": {"id": "user1"},
+				"action": "VIEW",
+				"environment": {"mfa": true},
+				"requests": [
+					{"object": {"type": "page"}},
+					{"object": {"type": "page"}, "environment": {"mfa": false}}
+				]
+			}`)),
+			expected: `{"results": [{"match": true, "id": "mfa_can_view"}, {"match": false}]}`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, tt.request)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			require.JSONEq(t, tt.expected, w.Body.String())
+		})
+	}
+}
+
+func TestServerObligations(t *testing.T) {
+	handler := &httpserver.Server{
+		Policies: []leges.Policy{
+			{
+				ID:        "owners_can_view",
+				Condition: `object.owner_id == subject.id`,
+				Actions:   []string{"VIEW"},
+				Obligations: []leges.Obligation{
+					{ID: "log_level", Value: "debug"},
+				},
+				Advice: []leges.Obligation{
+					{ID: "owner", Expression: `object.owner_id`},
+				},
+			},
+			{
+				ID:        "nobody_can_view_secrets",
+				Condition: `object.secret == true`,
+				Actions:   []string{"VIEW"},
+				Effect:    leges.EffectDeny,
+				Obligations: []leges.Obligation{
+					{ID: "log_level", Value: "warn"},
+				},
+			},
+		},
 	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/match", bytes.NewBufferString(`{
+		"subject": {"id": "user1"},
+		"object": {"owner_id": "user1"},
+		"action": "VIEW"
+	}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{
+		"match": true,
+		"id": "owners_can_view",
+		"obligations": {"log_level": "debug"},
+		"advice": {"owner": "user1"}
+	}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/match/batch", bytes.NewBufferString(`{
+		"subject": {"id": "user1"},
+		"action": "VIEW",
+		"requests": [
+			{"object": {"owner_id": "user1", "secret": true}},
+			{"object": {"owner_id": "user2"}}
+		]
+	}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"results": [
+		{"match": false, "id": "nobody_can_view_secrets", "obligations": {"log_level": "warn"}},
+		{"match": false}
+	]}`, w.Body.String())
+}
+
+func TestServerRedact(t *testing.T) {
+	handler := &httpserver.Server{
+		Policies: []leges.Policy{
+			{
+				ID:        "anyone_can_view_names",
+				Condition: `true`,
+				Actions:   []string{"VIEW"},
+				Fields:    []string{"id", "name"},
+			},
+			{
+				ID:        "owners_can_view_their_profile",
+				Condition: `object.id == subject.id`,
+				Actions:   []string{"VIEW"},
+			},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/redact", bytes.NewBufferString(`{
+		"subject": {"id": "user2"},
+		"object": {"id": "user1", "name": "Alice", "email": "alice@example.com"},
+		"action": "VIEW"
+	}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"object": {"id": "user1", "name": "Alice"}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/redact", bytes.NewBufferString(`{
+		"subject": {"id": "user1"},
+		"object": {"id": "user1", "name": "Alice", "email": "alice@example.com"},
+		"action": "VIEW"
+	}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"object": {"id": "user1", "name": "Alice", "email": "alice@example.com"}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/redact", bytes.NewBufferString(`{
+		"subject": {"id": "user1"},
+		"action": "VIEW"
+	}`)))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestServerUnencodableResponse(t *testing.T) {
+	handler := &httpserver.Server{
+		Policies: []leges.Policy{
+			{
+				ID:        "anyone_can_upload",
+				Condition: `true`,
+				Actions:   []string{"UPLOAD"},
+				Obligations: []leges.Obligation{
+					{ID: "usage", Expression: `object.used / object.quota`},
+				},
+			},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/match", bytes.NewBufferString(`{
+		"subject": {"id": "user1"},
+		"object": {"used": 1, "quota": 0},
+		"action": "UPLOAD"
+	}`)))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Contains(t, w.Body.String(), "unsupported value: +Inf")
+}
+
+func TestServerLimits(t *testing.T) {
+	handler := &httpserver.Server{
+		Policies: []leges.Policy{
+			{ID: "anyone_can_view", Condition: `true`, Actions: []string{"VIEW"}},
+		},
+		MaxBodyBytes: 200,
+		MaxBatchSize: 2,
+	}
+
+	serve := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", path, bytes.NewBufferString(body)))
+		return w
+	}
+
+	w := serve("/match", `{"subject": {"id": "user1"}, "object": {"id": "page1"}, "action": "VIEW"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve("/match", `{"subject": {"id": "user1"}, "object": {"id": "page1", "body": "`+strings.Repeat("x", 200)+`"}, "action": "VIEW"}`)
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	w = serve("/match/batch", `{"subject": {"id": "user1"}, "action": "VIEW", "requests": [{"object": {"id": "1"}}, {"object": {"id": "2"}}]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve("/match/batch", `{"subject": {"id": "user1"}, "action": "VIEW", "requests": [{"object": {"id": "1"}}, {"object": {"id": "2"}}, {"object": {"id": "3"}}]}`)
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	require.Contains(t, w.Body.String(), "at most 2")
+}
+
+func TestServerSetPolicies(t *testing.T) {
+	handler := &httpserver.Server{Policies: []leges.Policy{
+		{
+			ID:        "policy0",
+			Condition: "true",
+			Actions:   []string{"ACTION0"},
+		},
+	}}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	match := func(action string) string {
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		require.NoError(t, err)
+
+		params := req.URL.Query()
+		params.Set("subject", httpserver.MustMarshal(leges.Attributes{"k": "v"}))
+		params.Set("object", httpserver.MustMarshal(leges.Attributes{"k": "v"}))
+		params.Set("action", action)
+		req.URL.RawQuery = params.Encode()
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		resBody, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(resBody)
+	}
+
+	require.JSONEq(t, `{"match": true, "id": "policy0"}`, match("ACTION0"))
+	require.JSONEq(t, `{"match": false}`, match("ACTION1"))
+
+	err := handler.SetPolicies([]leges.Policy{
+		{
+			ID:        "policy1",
+			Condition: "true",
+			Actions:   []string{"ACTION1"},
+		},
+	})
+	require.NoError(t, err)
+
+	require.JSONEq(t, `{"match": false}`, match("ACTION0"))
+	require.JSONEq(t, `{"match": true, "id": "policy1"}`, match("ACTION1"))
+
+	err = handler.SetPolicies([]leges.Policy{
+		{
+			ID:        "policy2",
+			Condition: `"not a bool"`,
+			Actions:   []string{"ACTION2"},
+		},
+	})
+	require.Error(t, err)
+
+	require.JSONEq(t, `{"match": true, "id": "policy1"}`, match("ACTION1"))
+}
+
+func TestServerStatus(t *testing.T) {
+	handler := &httpserver.Server{}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	status := func() httpserver.Status {
+		res, err := http.Get(srv.URL + "/status")
+		require.NoError(t, err)
+
+		var status httpserver.Status
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&status))
+		require.Equal(t, handler.Status().Revision, status.Revision)
+		return status
+	}
+
+	require.Equal(t, 0, status().Revision)
+
+	err := handler.LoadPolicies(bytes.NewBufferString(`
+  - id: policy0
+    condition: "true"
+    actions: [VIEW]
+`))
+	require.NoError(t, err)
+	require.Equal(t, 1, status().Revision)
+	require.False(t, status().LoadedAt.IsZero())
+	require.Empty(t, status().LastReloadError)
+
+	err = handler.LoadPolicies(bytes.NewBufferString(`
+  - id: policy0
+     a
+`))
+	require.Error(t, err)
+	require.Equal(t, 1, status().Revision)
+	require.Equal(t, err.Error(), status().LastReloadError)
+
+	err = handler.LoadPolicies(bytes.NewBufferString(`
+  - id: policy0
+    condition: "1 + 1"
+    actions: [VIEW]
+`))
+	require.Error(t, err)
+	require.Equal(t, 1, status().Revision)
+	require.Equal(t, err.Error(), status().LastReloadError)
+
+	err = handler.LoadPolicies(bytes.NewBufferString(`
+  - id: policy1
+    condition: "true"
+    actions: [VIEW]
+`))
+	require.NoError(t, err)
+	require.Equal(t, 2, status().Revision)
+	require.Empty(t, status().LastReloadError)
+}
+
+func TestServerInvalidPolicies(t *testing.T) {
+	handler := &httpserver.Server{Policies: []leges.Policy{
+		{
+			ID:        "policy0",
+			Condition: "1 + 1",
+			Actions:   []string{"VIEW"},
+		},
+	}}
+
+	match := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/match", bytes.NewBufferString(`{
+			"subject": {"id": "user1"},
+			"object": {"id": "page1"},
+			"action": "VIEW"
+		}`)))
+		return w
+	}
+
+	w := match()
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Equal(t, 0, handler.Status().Revision)
+	require.NotEmpty(t, handler.Status().LastReloadError)
+	require.Contains(t, w.Body.String(), "policy0")
+
+	// the failure is kept, Policies are not compiled again
+	handler.Policies[0].Condition = "true"
+	require.Equal(t, http.StatusInternalServerError, match().Code)
+
+	err := handler.SetPolicies(handler.Policies)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, match().Code)
+	require.Empty(t, handler.Status().LastReloadError)
+}
+
+func BenchmarkServer(b *testing.B) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var policies []leges.Policy
+	for i := 0; i < 100; i++ {
+		policies = append(policies, leges.Policy{
+			ID: fmt.Sprintf("policy%d", i),
+			Condition: fmt.Sprintf(`
+				subject.role == "role%d"
+				and object.type in ["page", "adminpage"]
+			`, i),
+			Actions: []string{"VIEW", "UPDATE"},
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/match", nil)
+	params := req.URL.Query()
+	params.Set("subject", httpserver.MustMarshal(leges.Attributes{"role": "role99"}))
+	params.Set("object", httpserver.MustMarshal(leges.Attributes{"type": "page"}))
+	params.Set("action", "UPDATE")
+	req.URL.RawQuery = params.Encode()
+
+	b.Run("compile per request", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			srv := &httpserver.Server{Policies: policies}
+			srv.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	})
+
+	b.Run("compile once", func(b *testing.B) {
+		srv := &httpserver.Server{Policies: policies}
+		for i := 0; i < b.N; i++ {
+			srv.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	})
 }
 
 func TestLoadPoliciesFromYaml(t *testing.T) {
@@ -101,22 +812,51 @@ func TestLoadPoliciesFromYaml(t *testing.T) {
       and object.type == "page"
     actions:
       - VIEW
+    fields: [title, body]
+
+  - id: nobody_can_update_locked_pages
+    condition: |
+      object.locked
+    actions:
+      - UPDATE
+    effect: deny
+    priority: 10
+    obligations:
+      - id: log
+        value: {level: warn}
+      - id: owner
+        expression: object.owner_id
+    advice:
+      - id: reason
+        value: the page is locked
 `))
 	if err != nil {
 		panic(err)
 	}
 	require.NoError(t, err)
-	require.Len(t, policies, 2)
+	require.Len(t, policies, 3)
 	require.Equal(t, "admin_can_update_and_view_pages", policies[0].ID)
 	require.Equal(t, "guest_can_only_view_pages", policies[1].ID)
 
 	require.Equal(t, []string{"VIEW", "UPDATE"}, policies[0].Actions)
 	require.Equal(t, []string{"VIEW"}, policies[1].Actions)
+	require.Equal(t, []string{"title", "body"}, policies[1].Fields)
 
 	require.NotEmpty(t, policies[0].Condition)
 	require.NotEmpty(t, policies[1].Condition)
 	require.NotEqual(t, policies[0].Condition, policies[1].Condition)
 
+	require.Equal(t, leges.Effect(""), policies[0].Effect)
+	require.Equal(t, leges.EffectDeny, policies[2].Effect)
+	require.Equal(t, 10, policies[2].Priority)
+	require.Equal(t, []leges.Obligation{
+		{ID: "log", Value: map[string]interface{}{"level": "warn"}},
+		{ID: "owner", Expression: "object.owner_id"},
+	}, policies[2].Obligations)
+	require.Equal(t, []leges.Obligation{
+		{ID: "reason", Value: "the page is locked"},
+	}, policies[2].Advice)
+
 	policies, err = httpserver.LoadPoliciesFromYaml(bytes.NewBufferString(`
   - id: admin_can_update_and_view_pages
      a
@@ -126,6 +866,142 @@ func TestLoadPoliciesFromYaml(t *testing.T) {
 	require.Empty(t, policies)
 }
 
+func TestLoadSchemaFromYaml(t *testing.T) {
+	schema, err := httpserver.LoadSchemaFromYaml(bytes.NewBufferString(`
+subject:
+  type: object
+  properties:
+    role:
+      type: string
+object:
+  type: object
+  properties:
+    type: {"type": "string"}
+    tags: {"type": "array", "items": {"type": "string"}}
+`))
+	require.NoError(t, err)
+	require.Nil(t, schema.Environment)
+	require.Equal(t, "string", schema.Subject.Properties["role"].Type)
+	require.Equal(t, "string", schema.Object.Properties["type"].Type)
+	require.Equal(t, "string", schema.Object.Properties["tags"].Items.Type)
+
+	_, err = leges.NewLeges([]leges.Policy{
+		{
+			ID:        "policy1",
+			Condition: `subject.rol == "admin"`,
+			Actions:   []string{"VIEW"},
+		},
+	}, nil, leges.WithSchema(schema))
+	require.Error(t, err)
+
+	t.Run("JSON Schema document", func(t *testing.T) {
+		schema, err := httpserver.LoadSchemaFromYaml(bytes.NewBufferString(`{
+			"$schema": "https://json-schema.org/draft/2020-12/schema",
+			"type": "object",
+			"properties": {
+				"subject": {
+					"type": "object",
+					"properties": {"role": {"type": "string"}}
+				}
+			}
+		}`))
+		require.NoError(t, err)
+		require.Equal(t, "string", schema.Subject.Properties["role"].Type)
+
+		_, err = leges.NewLeges([]leges.Policy{
+			{
+				ID:        "policy1",
+				Condition: `subject.rol == "admin"`,
+				Actions:   []string{"VIEW"},
+			},
+		}, nil, leges.WithSchema(schema))
+		require.Error(t, err)
+	})
+
+	t.Run("err if the schema has no sections", func(t *testing.T) {
+		for _, document := range []string{
+			`{"$schema": "https://json-schema.org/draft/2020-12/schema", "type": "object"}`,
+			`{"type": "object", "properties": {"user": {"type": "object"}}}`,
+			`subjects: {type: object}`,
+		} {
+			_, err := httpserver.LoadSchemaFromYaml(bytes.NewBufferString(document))
+			require.True(t, errors.Is(err, leges.ErrInvalidSchema), "document %s: %v", document, err)
+		}
+	})
+}
+
+func TestLoadActionHierarchyFromYaml(t *testing.T) {
+	hierarchy, err := httpserver.LoadActionHierarchyFromYaml(bytes.NewBufferString(`
+MANAGE: [UPDATE, DELETE]
+UPDATE: [VIEW]
+`))
+	require.NoError(t, err)
+	require.Equal(t, leges.ActionHierarchy{
+		"MANAGE": {"UPDATE", "DELETE"},
+		"UPDATE": {"VIEW"},
+	}, hierarchy)
+
+	handler := &httpserver.Server{
+		Policies: []leges.Policy{
+			{
+				ID:        "admin_can_manage_pages",
+				Condition: `subject.role == "admin" and object.type == "page"`,
+				Actions:   []string{"MANAGE"},
+			},
+		},
+		ActionHierarchy: hierarchy,
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/match", bytes.NewBufferString(`{
+		"subject": {"role": "admin"},
+		"object": {"type": "page"},
+		"action": "VIEW"
+	}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"match": true, "id": "admin_can_manage_pages"}`, w.Body.String())
+}
+
+func TestLoadRolesFromYaml(t *testing.T) {
+	roles, err := httpserver.LoadRolesFromYaml(bytes.NewBufferString(`
+- name: admin
+  inherits: [editor]
+  permissions:
+    - actions: [DELETE]
+- name: editor
+  permissions:
+    - actions: [VIEW, UPDATE]
+      condition: object.type == "page"
+`))
+	require.NoError(t, err)
+	require.Equal(t, []leges.Role{
+		{
+			Name:     "admin",
+			Inherits: []string{"editor"},
+			Permissions: []leges.Permission{
+				{Actions: []string{"DELETE"}},
+			},
+		},
+		{
+			Name: "editor",
+			Permissions: []leges.Permission{
+				{Actions: []string{"VIEW", "UPDATE"}, Condition: `object.type == "page"`},
+			},
+		},
+	}, roles)
+
+	handler := &httpserver.Server{Roles: roles}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/match", bytes.NewBufferString(`{
+		"subject": {"role": "admin"},
+		"object": {"type": "page"},
+		"action": "UPDATE"
+	}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"match": true, "id": "role:editor:0"}`, w.Body.String())
+}
+
 func TestUnmarshalAttributes(t *testing.T) {
 	testCases := []struct {
 		yaml          string
@@ -0,0 +1,189 @@
+package leges
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/antonmedv/expr/ast"
+)
+
+// actionIndex maps actions to the policies listing them, so that matching a
+// request only walks the policies that may apply to its action
+type actionIndex struct {
+	// exact maps each action listed literally by a policy to the indexes of
+	// those policies in l.cachedPolicies, in ascending order
+	exact map[string][]int
+	// patterns are the indexes of the policies listing an action pattern,
+	// which are candidates for every action
+	patterns []int
+	// lastDenies maps each action listed literally by a deny policy to the
+	// index of the last of those policies
+	lastDenies map[string]int
+	// lastPatternDeny is the index of the last deny policy listing an
+	// action pattern, or -1
+	lastPatternDeny int
+}
+
+func newActionIndex(statutes []cachedPolicy) actionIndex {
+	index := actionIndex{
+		exact:           map[string][]int{},
+		lastDenies:      map[string]int{},
+		lastPatternDeny: -1,
+	}
+
+	for i, statute := range statutes {
+		pattern := false
+		for _, action := range statute.policy.Actions {
+			if strings.Contains(action, "*") {
+				pattern = true
+				continue
+			}
+			if !statute.policy.allows() {
+				index.lastDenies[action] = i
+			}
+			indexes := index.exact[action]
+			if len(indexes) == 0 || indexes[len(indexes)-1] != i {
+				index.exact[action] = append(indexes, i)
+			}
+		}
+		if pattern {
+			index.patterns = append(index.patterns, i)
+			if !statute.policy.allows() {
+				index.lastPatternDeny = i
+			}
+		}
+	}
+
+	return index
+}
+
+// candidates returns the indexes of the policies that may apply to the
+// requested action, in evaluation order. The policies must still be checked
+// with requested.appliesTo. The result must not be modified.
+func (index actionIndex) candidates(requested requestedAction) []int {
+	if len(index.patterns) == 0 && len(requested.allowing) == 1 && len(requested.denying) == 1 {
+		return index.exact[requested.allowing[0]]
+	}
+
+	seen := map[int]bool{}
+	var indexes []int
+	add := func(candidates []int) {
+		for _, i := range candidates {
+			if !seen[i] {
+				seen[i] = true
+				indexes = append(indexes, i)
+			}
+		}
+	}
+
+	add(index.patterns)
+	for _, action := range requested.allowing {
+		add(index.exact[action])
+	}
+	for _, action := range requested.denying {
+		add(index.exact[action])
+	}
+
+	sort.Ints(indexes)
+	return indexes
+}
+
+// lastDeny returns the index of the last deny policy that may apply to the
+// requested action, or -1 if there is none
+func (index actionIndex) lastDeny(requested requestedAction) int {
+	last := index.lastPatternDeny
+	for _, action := range requested.denying {
+		if i, ok := index.lastDenies[action]; ok && i > last {
+			last = i
+		}
+	}
+	return last
+}
+
+// guard is an equality between an attribute of the subject or the object and
+// a literal, such as object.type == "page", that a condition starts with
+type guard struct {
+	// path is the path of the attribute, including the root
+	path  []string
+	value interface{}
+}
+
+// guardsOf returns the leading conjuncts of a condition that are guards. If
+// any of them doesn't hold, the condition is false without being evaluated.
+// Conjuncts after the first one that isn't a guard are not included, since
+// they are not evaluated if an earlier conjunct fails. Equalities on
+// provided attributes are not guards, since the attributes may be missing
+// from the request.
+func (l *Leges) guardsOf(node ast.Node) []guard {
+	var guards []guard
+	for _, conjunct := range conjuncts(node) {
+		g, ok := guardOf(conjunct)
+		if !ok || l.provides(g.path) {
+			break
+		}
+		guards = append(guards, g)
+	}
+	return guards
+}
+
+// conjuncts returns the operands of nested and operators, in evaluation order
+func conjuncts(node ast.Node) []ast.Node {
+	if n, ok := node.(*ast.BinaryNode); ok && (n.Operator == "and" || n.Operator == "&&") {
+		return append(conjuncts(n.Left), conjuncts(n.Right)...)
+	}
+	return []ast.Node{node}
+}
+
+func guardOf(node ast.Node) (guard, bool) {
+	n, ok := node.(*ast.BinaryNode)
+	if !ok || n.Operator != "==" {
+		return guard{}, false
+	}
+
+	for _, operands := range [][2]ast.Node{{n.Left, n.Right}, {n.Right, n.Left}} {
+		path, ok := attributePath(operands[0])
+		if !ok || len(path) < 2 || (path[0] != "subject" && path[0] != "object") {
+			continue
+		}
+
+		// only literals whose equality in expr is the same as in Go
+		switch literal := operands[1].(type) {
+		case *ast.StringNode:
+			return guard{path: path, value: literal.Value}, true
+		case *ast.BoolNode:
+			return guard{path: path, value: literal.Value}, true
+		}
+	}
+
+	return guard{}, false
+}
+
+// fails reports whether the guard surely doesn't hold for the request. It is
+// false if the attribute can't be looked up without evaluating the condition.
+func (g guard) fails(request Request) bool {
+	var value interface{} = request.Subject
+	if g.path[0] == "object" {
+		value = request.Object
+	}
+
+	for _, key := range g.path[1:] {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		value = m[key]
+	}
+
+	return value != g.value
+}
+
+// guardsFail reports whether any of the guards of a policy surely doesn't
+// hold for the request
+func (statute cachedPolicy) guardsFail(request Request) bool {
+	for _, g := range statute.guards {
+		if g.fails(request) {
+			return true
+		}
+	}
+	return false
+}
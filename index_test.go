@@ -0,0 +1,239 @@
+package leges_test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEqualityGuards(t *testing.T) {
+	rules := mustNewLeges(t, []leges.Policy{
+		{
+			ID:        "pages_of_admins",
+			Condition: `object.type == "page" and subject.role == "admin"`,
+			Actions:   []string{"VIEW"},
+		},
+		{
+			ID:        "nested_guard",
+			Condition: `object.meta.kind == "post" and object.meta.published == true`,
+			Actions:   []string{"VIEW"},
+		},
+		{
+			ID:        "guard_after_failing_expression",
+			Condition: `object.size > 0 and object.type == "file"`,
+			Actions:   []string{"VIEW"},
+		},
+	}, nil)
+
+	testCases := []struct {
+		name             string
+		subject          leges.Attributes
+		object           leges.Attributes
+		expectedOk       bool
+		expectedPolicyID string
+		expectedErr      bool
+	}{
+		{
+			name:             "guards hold",
+			subject:          leges.Attributes{"role": "admin"},
+			object:           leges.Attributes{"type": "page", "meta": leges.Attributes{}, "size": 1},
+			expectedOk:       true,
+			expectedPolicyID: "pages_of_admins",
+		},
+		{
+			name:    "guard fails",
+			subject: leges.Attributes{"role": "editor"},
+			object:  leges.Attributes{"type": "page", "meta": leges.Attributes{}, "size": 1},
+		},
+		{
+			name:             "nested guard holds",
+			subject:          leges.Attributes{"role": "editor"},
+			object:           leges.Attributes{"meta": leges.Attributes{"kind": "post", "published": true}, "size": 1},
+			expectedOk:       true,
+			expectedPolicyID: "nested_guard",
+		},
+		{
+			name:    "missing attribute fails the guard",
+			subject: leges.Attributes{"role": "editor"},
+			object:  leges.Attributes{"meta": leges.Attributes{}, "size": 1},
+		},
+		{
+			name:        "condition is evaluated if the guard can't be looked up",
+			subject:     leges.Attributes{"role": "editor"},
+			object:      leges.Attributes{"type": "doc", "size": 1},
+			expectedErr: true,
+		},
+		{
+			name:        "errors before guards are reported",
+			subject:     leges.Attributes{"role": "editor"},
+			object:      leges.Attributes{"type": "file", "meta": leges.Attributes{}, "size": "big"},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ok, policy, err := rules.Match(leges.Request{
+				Action:  "VIEW",
+				Subject: tc.subject,
+				Object:  tc.object,
+			})
+			if tc.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOk, ok)
+			if tc.expectedOk {
+				require.Equal(t, tc.expectedPolicyID, policy.ID)
+			}
+		})
+	}
+}
+
+func TestEqualityGuardsSkipEvaluation(t *testing.T) {
+	var evaluations int64
+	count := func() bool {
+		atomic.AddInt64(&evaluations, 1)
+		return true
+	}
+
+	rules := mustNewLeges(t, []leges.Policy{
+		{
+			ID:        "pages",
+			Condition: `object.type == "page" and subject.count()`,
+			Actions:   []string{"VIEW"},
+		},
+	}, nil)
+
+	for _, objectType := range []string{"page", "post", "post"} {
+		_, _, err := rules.Match(leges.Request{
+			Action:  "VIEW",
+			Subject: leges.Attributes{"count": count},
+			Object:  leges.Attributes{"type": objectType},
+		})
+		require.NoError(t, err)
+	}
+	require.Equal(t, int64(1), atomic.LoadInt64(&evaluations))
+}
+
+func TestStopAfterLastDeny(t *testing.T) {
+	var evaluations int64
+	count := func() bool {
+		atomic.AddInt64(&evaluations, 1)
+		return true
+	}
+
+	rules := mustNewLeges(t, []leges.Policy{
+		{ID: "p1", Condition: `subject.count()`, Actions: []string{"VIEW"}},
+		{ID: "p2", Condition: `object.locked == true`, Actions: []string{"VIEW"}, Effect: leges.EffectDeny},
+		{ID: "p3", Condition: `subject.count()`, Actions: []string{"VIEW"}},
+		{ID: "p4", Condition: `subject.count()`, Actions: []string{"VIEW"}},
+		{ID: "p5", Condition: `subject.count()`, Actions: []string{"EDIT"}, Effect: leges.EffectDeny},
+	}, nil)
+
+	ok, policy, err := rules.Match(leges.Request{
+		Action:  "VIEW",
+		Subject: leges.Attributes{"count": count},
+		Object:  leges.Attributes{"locked": false},
+	})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "p1", policy.ID)
+	require.Equal(t, int64(1), atomic.LoadInt64(&evaluations))
+}
+
+func TestActionIndex(t *testing.T) {
+	var policies []leges.Policy
+	for i := 0; i < 100; i++ {
+		policies = append(policies, leges.Policy{
+			ID:        fmt.Sprintf("policy%d", i),
+			Condition: fmt.Sprintf(`subject.level >= %d`, i),
+			Actions:   []string{fmt.Sprintf("ACTION%d", i%10), "ALL"},
+		})
+	}
+	policies = append(policies, leges.Policy{
+		ID:        "pattern",
+		Condition: `subject.level >= 0`,
+		Actions:   []string{"ACTION*"},
+		Priority:  -1,
+	})
+
+	rules := mustNewLeges(t, policies, nil)
+
+	for _, action := range []string{"ACTION3", "ALL", "ACTION42"} {
+		all, err := rules.MatchAll(leges.Request{
+			Action:  action,
+			Subject: leges.Attributes{"level": 95},
+			Object:  leges.Attributes{"type": "page"},
+		})
+		require.NoError(t, err)
+
+		var ids []string
+		for _, policy := range all {
+			ids = append(ids, policy.ID)
+		}
+
+		switch action {
+		case "ACTION3":
+			require.Equal(t, []string{"policy3", "policy13", "policy23", "policy33", "policy43", "policy53", "policy63", "policy73", "policy83", "policy93", "pattern"}, ids)
+		case "ALL":
+			require.Len(t, ids, 96)
+		case "ACTION42":
+			require.Equal(t, []string{"pattern"}, ids)
+		}
+	}
+}
+
+func BenchmarkMatchManyPolicies(b *testing.B) {
+	for _, n := range []int{100, 1000, 3000} {
+		for _, guarded := range []bool{true, false} {
+			b.Run(fmt.Sprintf("policies=%d/guarded=%v", n, guarded), func(b *testing.B) {
+				benchmarkMatchManyPolicies(b, n, guarded)
+			})
+		}
+	}
+}
+
+// benchmarkMatchManyPolicies matches a request against n policies spread over
+// 10 actions and 100 object types. Guarded conditions start with equalities
+// that are checked without evaluating the condition.
+func benchmarkMatchManyPolicies(b *testing.B, n int, guarded bool) {
+	format := `object.type == "type%d" and subject.id == "user%d"`
+	if !guarded {
+		format = `subject.id startsWith "user" and object.type == "type%d" and subject.id == "user%d"`
+	}
+
+	policies := make([]leges.Policy, n)
+	for i := range policies {
+		policies[i] = leges.Policy{
+			ID:        fmt.Sprintf("policy%d", i),
+			Condition: fmt.Sprintf(format, i%100, i),
+			Actions:   []string{fmt.Sprintf("ACTION%d", i%10)},
+		}
+	}
+
+	rules, err := leges.NewLeges(policies, nil)
+	require.NoError(b, err)
+
+	// the last policy of the action matches
+	last := n - 1
+	request := leges.Request{
+		Action:  fmt.Sprintf("ACTION%d", last%10),
+		Subject: leges.Attributes{"id": fmt.Sprintf("user%d", last)},
+		Object:  leges.Attributes{"type": fmt.Sprintf("type%d", last%100)},
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ok, _, err := rules.Match(request)
+			if !ok || err != nil {
+				b.Fatalf("ok=%v err=%v", ok, err)
+			}
+		}
+	})
+}
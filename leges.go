@@ -1,20 +1,91 @@
 package leges
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/parser"
 	"github.com/antonmedv/expr/vm"
+	"sort"
+	"sync/atomic"
+	"time"
 )
 
-var ErrDuplicatePolicyID = errors.New("duplicate policies with id")
+var (
+	ErrDuplicatePolicyID = errors.New("duplicate policies with id")
+	ErrEvaluationTimeout = errors.New("evaluation timed out")
+	ErrTooManyTimeouts   = errors.New("too many timed out evaluations are still running")
+)
+
+// MaxTimedOutEvaluations is the number of evaluations of a Leges that
+// exceeded their budget and still run in the background, see
+// WithEvaluationTimeout, above which its evaluations fail with
+// ErrTooManyTimeouts without being started.
+const MaxTimedOutEvaluations = 64
 
 // Leges is the law book, holding all policies and the base environment
 type Leges struct {
-	// cachedPolicies is a list of cachedPolicy, making the law
-	cachedPolicies map[string]cachedPolicy
+	// timedOut is the number of evaluations that exceeded their budget and
+	// are still running
+	timedOut int32
+	// cachedPolicies is a list of cachedPolicy, making the law. It is sorted
+	// by priority, policies with equal priorities keep the order they were
+	// loaded in.
+	cachedPolicies []cachedPolicy
+	// index maps actions to the candidate policies in cachedPolicies
+	index actionIndex
 	// environment are a set of attributes that are always merged with the request
 	environment Attributes
+	// algorithm combines the effects of the applicable policies
+	algorithm CombiningAlgorithm
+	// evaluationTimeout is the budget of evaluating a single condition, zero
+	// means no limit
+	evaluationTimeout time.Duration
+	// schema is used to type-check conditions, nil means no type-checking
+	schema *Schema
+	// batchConcurrency is the number of requests of a batch matched
+	// concurrently
+	batchConcurrency int
+	// hierarchy is the declared action hierarchy. impliers maps each action
+	// to itself and the actions that imply it, and implied maps each action
+	// to itself and the actions it implies.
+	hierarchy ActionHierarchy
+	impliers  map[string][]string
+	implied   map[string][]string
+	// roles are the roles given to WithRoles, and inheritedRoles maps each
+	// role to the set of itself and the roles it inherits
+	roles          []Role
+	inheritedRoles map[string]map[string]bool
+	// tuples is the relationship store of the related function, nil means
+	// related is not available, and rewrites are its relation rewrites
+	tuples   TupleStore
+	rewrites map[string]RelationRewrite
+	// providers maps provided attributes, such as "subject.groups", to their
+	// providers
+	providers map[string]AttributeProvider
+	// functions are the functions registered with WithFunctions
+	functions map[string]interface{}
+	// tracer receives the values passed to debug(), nil means they are
+	// dropped
+	tracer Tracer
+	// failureMode decides what happens when a condition fails to evaluate
+	failureMode FailureMode
+}
+
+// Option configures a Leges when it is constructed
+type Option func(*Leges) error
+
+// WithCombiningAlgorithm sets the algorithm used when more than one policy is
+// applicable to a request. The default is DenyOverrides.
+func WithCombiningAlgorithm(algorithm CombiningAlgorithm) Option {
+	return func(l *Leges) error {
+		if err := algorithm.Validate(); err != nil {
+			return err
+		}
+		l.algorithm = algorithm
+		return nil
+	}
 }
 
 type ErrExprRunFailed struct {
@@ -38,6 +109,22 @@ type ErrExprCompileFailed struct {
 	Err         error
 }
 
+// ErrBudgetExceeded is returned when the evaluation of a condition exceeds
+// the evaluation budget. It wraps an ErrExprRunFailed, which in turn wraps
+// ErrEvaluationTimeout.
+type ErrBudgetExceeded struct {
+	Budget time.Duration
+	Err    *ErrExprRunFailed
+}
+
+func (e *ErrBudgetExceeded) Unwrap() error {
+	return e.Err
+}
+
+func (e *ErrBudgetExceeded) Error() string {
+	return fmt.Sprintf("evaluation budget of %s exceeded", e.Budget)
+}
+
 func (e *ErrExprCompileFailed) Unwrap() error {
 	return e.Err
 }
@@ -50,19 +137,61 @@ func (e *ErrExprCompileFailed) Error() string {
 type cachedPolicy struct {
 	policy  Policy
 	program *vm.Program
+	// tree is the parsed condition, it must not be modified
+	tree *parser.Tree
+	// usesObject is false if the condition does not refer to the object, in
+	// which case its result only depends on the subject and the environment
+	usesObject bool
+	// guards are the equalities with literals the condition starts with
+	guards []guard
+	// obligations and advice are the compiled obligations and advice of the
+	// policy
+	obligations []compiledObligation
+	advice      []compiledObligation
 }
 
 // Attributes is a set of key-value attributes for objects and subjects.
 type Attributes = map[string]interface{}
 
+// WithEvaluationTimeout limits the wall time of evaluating the condition of a
+// single policy. Evaluations that take longer fail with ErrBudgetExceeded.
+// The sub-expressions evaluated by Explain, ObjectFilter and
+// SubjectConstraints are limited the same way, each on its own.
+//
+// Since expressions can't be interrupted, an evaluation that exceeds the
+// budget carries on in the background until it finishes, but its result is
+// discarded, so the budget bounds the latency of a request but not the CPU it
+// uses. To keep a pathological condition from piling up such evaluations,
+// once MaxTimedOutEvaluations of them are running, evaluations fail with
+// ErrTooManyTimeouts without being started, until some of them finish.
+func WithEvaluationTimeout(timeout time.Duration) Option {
+	return func(l *Leges) error {
+		l.evaluationTimeout = timeout
+		return nil
+	}
+}
+
 // NewLeges construct a leges struct
-func NewLeges(policies []Policy, env Attributes) (*Leges, error) {
-	leges := &Leges{}
+func NewLeges(policies []Policy, env Attributes, opts ...Option) (*Leges, error) {
+	leges := &Leges{
+		algorithm:   DenyOverrides,
+		failureMode: FailClosed,
+	}
+
+	for _, opt := range opts {
+		if err := opt(leges); err != nil {
+			return nil, err
+		}
+	}
 
 	if err := leges.loadEnvironment(env); err != nil {
 		return nil, err
 	}
 
+	if roles := leges.rolePolicies(); len(roles) > 0 {
+		policies = append(append([]Policy{}, policies...), roles...)
+	}
+
 	if err := leges.loadPolicies(policies); err != nil {
 		return nil, err
 	}
@@ -83,18 +212,23 @@ func (l *Leges) loadEnvironment(env Attributes) error {
 // loadPolicies iterates over a list of policies, runs validation on each of them and
 // keeps a compiled program of the condition for further use cases
 func (l *Leges) loadPolicies(polices []Policy) error {
-	l.cachedPolicies = make(map[string]cachedPolicy, len(polices))
+	l.cachedPolicies = make([]cachedPolicy, 0, len(polices))
+	ids := make(map[string]struct{}, len(polices))
 
 	for _, policy := range polices {
 		if err := policy.Validate(); err != nil {
 			return err
 		}
 
-		if _, ok := l.cachedPolicies[policy.ID]; ok {
+		if _, ok := ids[policy.ID]; ok {
 			return fmt.Errorf("id=%q: %w", policy.ID, ErrDuplicatePolicyID)
 		}
+		ids[policy.ID] = struct{}{}
 
-		program, err := policy.compileCondition()
+		program, tree, err := policy.compileCondition(l.compileOptions()...)
+		if nonBooleanErr, ok := err.(*ErrNonBooleanCondition); ok {
+			return nonBooleanErr
+		}
 		if err != nil {
 			return &ErrExprCompileFailed{
 				Environment: l.environment,
@@ -103,17 +237,50 @@ func (l *Leges) loadPolicies(polices []Policy) error {
 			}
 		}
 
-		l.cachedPolicies[policy.ID] = cachedPolicy{
-			policy:  policy,
-			program: program,
+		if l.schema != nil {
+			if err := l.schema.check(policy, l.functions); err != nil {
+				return err
+			}
+		}
+
+		obligations, advice, err := l.compileObligations(policy)
+		if err != nil {
+			return err
 		}
+
+		l.cachedPolicies = append(l.cachedPolicies, cachedPolicy{
+			policy:      policy,
+			program:     program,
+			tree:        tree,
+			usesObject:  refersTo(tree.Node, "object"),
+			guards:      l.guardsOf(tree.Node),
+			obligations: obligations,
+			advice:      advice,
+		})
 	}
 
+	sort.SliceStable(l.cachedPolicies, func(i, j int) bool {
+		return l.cachedPolicies[i].policy.Priority > l.cachedPolicies[j].policy.Priority
+	})
+	l.index = newActionIndex(l.cachedPolicies)
+
 	return nil
 }
 
+// compileOptions returns the options of compiling conditions
+func (l *Leges) compileOptions() []expr.Option {
+	var opts []expr.Option
+	if len(l.functions) > 0 {
+		opts = append(opts, expr.Env(l.functions), expr.AllowUndefinedVariables())
+	}
+	if len(l.providers) > 0 {
+		opts = append(opts, expr.Patch(attributePatcher{leges: l}))
+	}
+	return opts
+}
+
 // normalizeRequest normalizes the request by merging it with environment
-func (l *Leges) normalizeRequest(request Request) Attributes {
+func (l *Leges) normalizeRequest(ctx context.Context, request Request) Attributes {
 	req := Attributes{}
 
 	// copy all environment to req
@@ -125,50 +292,217 @@ func (l *Leges) normalizeRequest(request Request) Attributes {
 	req["subject"] = request.Subject
 	req["object"] = request.Object
 
-	req["debug"] = func(value interface{}) bool {
-		fmt.Printf("%#v\n", value)
-		return true
+	// env holds the environment of the request, falling back to the
+	// environment given to NewLeges
+	env := Attributes{}
+	for k, v := range l.environment {
+		env[k] = v
+	}
+	for k, v := range request.Environment {
+		env[k] = v
+	}
+	req["env"] = env
+
+	req["debug"] = l.debugFunc(ctx, nil)
+	req["hasRole"] = l.hasRole
+
+	for name, fn := range l.functions {
+		req[name] = fn
+	}
+
+	if l.tuples != nil {
+		checker := &relationChecker{
+			ctx:      ctx,
+			store:    l.tuples,
+			rewrites: l.rewrites,
+			checked:  map[Tuple]bool{},
+		}
+		req["related"] = checker.related
+	}
+
+	if len(l.providers) > 0 {
+		resolver := &attributeResolver{
+			ctx:       ctx,
+			providers: l.providers,
+			request:   request,
+			resolved:  map[string]resolvedAttribute{},
+		}
+		req[attributeFunction] = resolver.attribute
+		req[attributeResolverKey] = resolver
 	}
 
 	return req
 }
 
-// Match checks a request against policies and returns whether the request matches any of the policies.
+// Match checks a request against policies and returns whether the request is
+// granted, combining the effects of the applicable policies with the combining
+// algorithm of l. The returned policy is the one that decided the result, it is
+// nil if no policy is applicable. Match doesn't evaluate obligations or
+// advice, so a request that Match grants may still fail for
+// MatchWithObligations if one of its obligations fails to evaluate. Callers
+// who enforce obligations should use MatchWithObligations, or Decide for a
+// more detailed result.
 func (l *Leges) Match(request Request) (bool, *Policy, error) {
+	return l.MatchContext(context.Background(), request)
+}
+
+// MatchWithObligations is like Match, but also evaluates the obligations and
+// the advice of the policies that decided the result, see Decision. An
+// obligation that fails to evaluate fails the request, in which case the
+// result is refused and Err is the error of the obligation.
+func (l *Leges) MatchWithObligations(request Request) MatchResult {
+	return l.MatchWithObligationsContext(context.Background(), request)
+}
+
+// MatchWithObligationsContext is like MatchWithObligations, but stops
+// evaluating policies as soon as ctx is done, in which case Err is the error
+// of ctx.
+func (l *Leges) MatchWithObligationsContext(ctx context.Context, request Request) MatchResult {
+	return l.decide(ctx, request, nil, decideObligations).matchResult()
+}
+
+// MatchContext is like Match, but stops evaluating policies and returns the
+// error of ctx as soon as ctx is done.
+func (l *Leges) MatchContext(ctx context.Context, request Request) (bool, *Policy, error) {
+	return l.decide(ctx, request, nil, decideVerdict).result()
+}
+
+// MatchAll returns every policy whose actions include the requested action and
+// whose condition holds for the request, in evaluation order. Unlike Match, it
+// does not combine the policies, so the result includes policies of both
+// effects.
+func (l *Leges) MatchAll(request Request) ([]Policy, error) {
 	if err := request.Validate(); err != nil {
-		return false, nil, err
+		return nil, err
 	}
 
-	normalizedRequest := l.normalizeRequest(request)
+	ctx := context.Background()
+	normalizedRequest := l.normalizeRequest(ctx, request)
+	var policies []Policy
+	requested := l.requestedAction(request.Action)
 
-	for _, statute := range l.cachedPolicies {
-		if !sliceIncludes(statute.policy.Actions, request.Action) {
+	for _, i := range l.index.candidates(requested) {
+		statute := l.cachedPolicies[i]
+		if !requested.appliesTo(statute.policy) || statute.guardsFail(request) {
 			continue
 		}
 
-		output, err := expr.Run(statute.program, normalizedRequest)
+		ok, err := l.run(ctx, statute, request, normalizedRequest)
 		if err != nil {
-			return false, nil, &ErrExprRunFailed{
-				Err:         err,
-				Environment: l.environment,
-				Policy:      statute.policy,
-				Request:     request,
+			if l.skipsErrors(ctx) {
+				continue
 			}
+			return nil, err
 		}
 
-		if output.(bool) {
-			return true, &statute.policy, nil
+		if ok {
+			policies = append(policies, statute.policy)
 		}
 	}
 
-	return false, nil, nil
+	return policies, nil
 }
 
-func sliceIncludes(slice []string, needle string) bool {
-	for _, item := range slice {
-		if item == needle {
-			return true
+// run evaluates the condition of a policy against a normalized request
+func (l *Leges) run(ctx context.Context, statute cachedPolicy, request Request, normalizedRequest Attributes) (bool, error) {
+	output, err := l.evaluate(ctx, statute, statute.program, request, normalizedRequest)
+	if err != nil {
+		return false, err
+	}
+
+	ok, isBool := output.(bool)
+	if !isBool {
+		return false, &ErrNonBooleanCondition{
+			PolicyID: statute.policy.ID,
+			Type:     fmt.Sprintf("%T", output),
 		}
 	}
-	return false
+
+	return ok, nil
+}
+
+// evaluate runs a program of a policy against a normalized request
+func (l *Leges) evaluate(ctx context.Context, statute cachedPolicy, program *vm.Program, request Request, normalizedRequest Attributes) (interface{}, error) {
+	var (
+		output interface{}
+		err    error
+	)
+	if l.evaluationTimeout > 0 {
+		output, err = l.runWithTimeout(ctx, program, normalizedRequest)
+	} else {
+		output, err = expr.Run(program, normalizedRequest)
+	}
+
+	if err != nil {
+		if err == ctx.Err() {
+			return nil, err
+		}
+
+		runErr := &ErrExprRunFailed{
+			Err:         err,
+			Environment: l.environment,
+			Policy:      statute.policy,
+			Request:     request,
+		}
+		if err == ErrEvaluationTimeout {
+			return nil, &ErrBudgetExceeded{
+				Budget: l.evaluationTimeout,
+				Err:    runErr,
+			}
+		}
+		return nil, runErr
+	}
+
+	return output, nil
+}
+
+// runWithTimeout runs a program in a separate goroutine and waits for it
+// until the evaluation timeout passes or ctx is done. The goroutines that are
+// still running after that are counted in l.timedOut until they finish.
+func (l *Leges) runWithTimeout(ctx context.Context, program *vm.Program, env Attributes) (interface{}, error) {
+	if atomic.LoadInt32(&l.timedOut) >= MaxTimedOutEvaluations {
+		return nil, ErrTooManyTimeouts
+	}
+
+	type result struct {
+		output interface{}
+		err    error
+	}
+
+	const (
+		running int32 = iota
+		finished
+		abandoned
+	)
+	state := running
+
+	done := make(chan result, 1)
+	go func() {
+		output, err := expr.Run(program, env)
+		done <- result{output, err}
+		if !atomic.CompareAndSwapInt32(&state, running, finished) {
+			atomic.AddInt32(&l.timedOut, -1)
+		}
+	}()
+
+	timer := time.NewTimer(l.evaluationTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case r := <-done:
+		return r.output, r.err
+	case <-timer.C:
+		err = ErrEvaluationTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// the counter is incremented before the state changes, so that the
+	// goroutine can't decrement it first
+	atomic.AddInt32(&l.timedOut, 1)
+	if !atomic.CompareAndSwapInt32(&state, running, abandoned) {
+		atomic.AddInt32(&l.timedOut, -1)
+	}
+	return nil, err
 }
@@ -553,3 +553,201 @@ func mustNewLeges(t *testing.T, policies []leges.Policy, sharedEnv leges.Attribu
 	require.NoError(t, err)
 	return rules
 }
+
+func TestPolicyOrder(t *testing.T) {
+	request := leges.Request{
+		Action: "VIEW",
+		Subject: leges.Attributes{
+			"id": "user1",
+		},
+		Object: leges.Attributes{
+			"owner_id": "user1",
+		},
+	}
+
+	t.Run("report the first loaded policy", func(t *testing.T) {
+		rules := mustNewLeges(t, []leges.Policy{
+			{ID: "policy1", Condition: "true", Actions: []string{"VIEW"}},
+			{ID: "policy2", Condition: "true", Actions: []string{"VIEW"}},
+			{ID: "policy3", Condition: "true", Actions: []string{"VIEW"}},
+		}, nil)
+
+		for i := 0; i < 100; i++ {
+			ok, policy, err := rules.Match(request)
+			require.NoError(t, err)
+			require.Equal(t, true, ok)
+			require.Equal(t, "policy1", policy.ID)
+		}
+	})
+
+	t.Run("report the policy with the highest priority", func(t *testing.T) {
+		rules := mustNewLeges(t, []leges.Policy{
+			{ID: "policy1", Condition: "true", Actions: []string{"VIEW"}},
+			{ID: "policy2", Condition: "true", Actions: []string{"VIEW"}, Priority: 10},
+			{ID: "policy3", Condition: "true", Actions: []string{"VIEW"}, Priority: 10},
+			{ID: "policy4", Condition: "true", Actions: []string{"VIEW"}, Priority: -1},
+		}, nil)
+
+		for i := 0; i < 100; i++ {
+			ok, policy, err := rules.Match(request)
+			require.NoError(t, err)
+			require.Equal(t, true, ok)
+			require.Equal(t, "policy2", policy.ID)
+		}
+	})
+}
+
+func TestMatchAll(t *testing.T) {
+	rules := mustNewLeges(t, []leges.Policy{
+		{
+			ID:        "owner_can_view",
+			Condition: `object.owner_id == subject.id`,
+			Actions:   []string{"VIEW"},
+		},
+		{
+			ID:        "admin_can_view",
+			Condition: `subject.role == "admin"`,
+			Actions:   []string{"VIEW", "UPDATE"},
+		},
+		{
+			ID:        "guest_can_view",
+			Condition: `subject.role == "guest"`,
+			Actions:   []string{"VIEW"},
+		},
+		{
+			ID:        "admin_can_update",
+			Condition: `subject.role == "admin"`,
+			Actions:   []string{"UPDATE"},
+		},
+		{
+			ID:        "nobody_can_view_secrets",
+			Condition: `object.secret == true`,
+			Actions:   []string{"VIEW"},
+			Effect:    leges.EffectDeny,
+		},
+	}, nil)
+
+	t.Run("return every matching policy", func(t *testing.T) {
+		policies, err := rules.MatchAll(leges.Request{
+			Action: "VIEW",
+			Subject: leges.Attributes{
+				"id":   "user1",
+				"role": "admin",
+			},
+			Object: leges.Attributes{
+				"owner_id": "user1",
+				"secret":   true,
+			},
+		})
+		require.NoError(t, err)
+
+		var ids []string
+		for _, policy := range policies {
+			ids = append(ids, policy.ID)
+		}
+		require.Equal(t, []string{"owner_can_view", "admin_can_view", "nobody_can_view_secrets"}, ids)
+	})
+
+	t.Run("return nothing if no policy matches", func(t *testing.T) {
+		policies, err := rules.MatchAll(leges.Request{
+			Action: "DELETE",
+			Subject: leges.Attributes{
+				"role": "admin",
+			},
+			Object: leges.Attributes{
+				"owner_id": "user1",
+			},
+		})
+		require.NoError(t, err)
+		require.Empty(t, policies)
+	})
+
+	t.Run("error if request is invalid", func(t *testing.T) {
+		_, err := rules.MatchAll(leges.Request{
+			Action: "VIEW",
+			Subject: leges.Attributes{
+				"role": "admin",
+			},
+		})
+		require.Equal(t, leges.ErrEmptyObjectAttrs, err)
+	})
+}
+
+func TestNonBooleanCondition(t *testing.T) {
+	t.Run("err if condition type is not bool when loading", func(t *testing.T) {
+		for condition, expectedType := range map[string]string{
+			`"admin"`:          "string",
+			`1 + 2`:            "int",
+			`[true]`:           "[]interface {}",
+			`nil`:              "nil",
+			`(nil)`:            "nil",
+			`true ? nil : nil`: "nil",
+		} {
+			_, err := leges.NewLeges([]leges.Policy{
+				{ID: "policy1", Condition: condition, Actions: []string{"VIEW"}},
+			}, nil)
+
+			var nonBooleanErr *leges.ErrNonBooleanCondition
+			require.True(t, errors.As(err, &nonBooleanErr), "condition: %s", condition)
+			require.Equal(t, "policy1", nonBooleanErr.PolicyID)
+			require.Equal(t, expectedType, nonBooleanErr.Type, "condition: %s", condition)
+		}
+	})
+
+	t.Run("err if attribute type is not bool when loading with a schema", func(t *testing.T) {
+		_, err := leges.NewLeges([]leges.Policy{
+			{ID: "policy1", Condition: `subject.role`, Actions: []string{"VIEW"}},
+		}, nil, leges.WithSchema(&leges.Schema{
+			Subject: &leges.AttributeSchema{
+				Type: "object",
+				Properties: map[string]*leges.AttributeSchema{
+					"role": {Type: "string"},
+				},
+			},
+		}))
+
+		var nonBooleanErr *leges.ErrNonBooleanCondition
+		require.True(t, errors.As(err, &nonBooleanErr))
+		require.Equal(t, "policy1", nonBooleanErr.PolicyID)
+		require.Equal(t, "string", nonBooleanErr.Type)
+	})
+
+	t.Run("err if condition result is not bool when matching", func(t *testing.T) {
+		rules := mustNewLeges(t, []leges.Policy{
+			{ID: "policy1", Condition: `subject.role`, Actions: []string{"VIEW"}},
+		}, nil)
+
+		_, _, err := rules.Match(leges.Request{
+			Action: "VIEW",
+			Subject: leges.Attributes{
+				"role": "admin",
+			},
+			Object: leges.Attributes{
+				"type": "page",
+			},
+		})
+
+		var nonBooleanErr *leges.ErrNonBooleanCondition
+		require.True(t, errors.As(err, &nonBooleanErr))
+		require.Equal(t, "policy1", nonBooleanErr.PolicyID)
+		require.Equal(t, "string", nonBooleanErr.Type)
+	})
+
+	t.Run("match a bool attribute", func(t *testing.T) {
+		rules := mustNewLeges(t, []leges.Policy{
+			{ID: "policy1", Condition: `subject.is_admin`, Actions: []string{"VIEW"}},
+		}, nil)
+
+		ok, _, err := rules.Match(leges.Request{
+			Action: "VIEW",
+			Subject: leges.Attributes{
+				"is_admin": true,
+			},
+			Object: leges.Attributes{
+				"type": "page",
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, true, ok)
+	})
+}
@@ -0,0 +1,101 @@
+package leges
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
+)
+
+var ErrInvalidObligation = errors.New("invalid obligation")
+
+// Obligation is something the caller has to do when a policy decides a
+// request, such as logging the request at a higher level or requiring step-up
+// MFA. Its value is either Value, or the result of evaluating Expression
+// against the request, for example:
+//
+//	Obligation{ID: "log_level", Value: "warn"}
+//	Obligation{ID: "mask_fields", Expression: `object.owner_id == subject.id ? [] : ["email"]`}
+type Obligation struct {
+	// ID names the obligation, such as "require_mfa"
+	ID string
+	// Value is the value of the obligation if Expression is empty
+	Value interface{}
+	// Expression is evaluated like a condition, it sees the subject, the
+	// object, env.* and the registered functions
+	Expression string
+}
+
+func (o Obligation) validate(policyID string) error {
+	if o.ID == "" {
+		return fmt.Errorf("policy=%q: obligation with empty id: %w", policyID, ErrInvalidObligation)
+	}
+	if o.Expression != "" && o.Value != nil {
+		return fmt.Errorf("policy=%q obligation=%q: both value and expression are set: %w", policyID, o.ID, ErrInvalidObligation)
+	}
+	return nil
+}
+
+// compiledObligation is an obligation and the compiled program of its
+// expression, which is nil if the obligation has a static value
+type compiledObligation struct {
+	Obligation
+	program *vm.Program
+}
+
+// compileObligations compiles the obligations and the advice of a policy
+func (l *Leges) compileObligations(policy Policy) (obligations, advice []compiledObligation, err error) {
+	compile := func(all []Obligation) ([]compiledObligation, error) {
+		var compiled []compiledObligation
+		for _, o := range all {
+			c := compiledObligation{Obligation: o}
+			if o.Expression != "" {
+				c.program, err = expr.Compile(o.Expression, l.compileOptions()...)
+				if err != nil {
+					return nil, &ErrExprCompileFailed{
+						Environment: l.environment,
+						Policy:      policy,
+						Err:         err,
+					}
+				}
+			}
+			compiled = append(compiled, c)
+		}
+		return compiled, nil
+	}
+
+	if obligations, err = compile(policy.Obligations); err != nil {
+		return nil, nil, err
+	}
+	if advice, err = compile(policy.Advice); err != nil {
+		return nil, nil, err
+	}
+	return obligations, advice, nil
+}
+
+// fulfill evaluates obligations against a normalized request and adds their
+// values to values, keeping the values already set
+func (l *Leges) fulfill(ctx context.Context, statute cachedPolicy, obligations []compiledObligation, request Request, normalizedRequest Attributes, values map[string]interface{}) error {
+	for _, o := range obligations {
+		if _, ok := values[o.ID]; ok {
+			continue
+		}
+		if o.program == nil {
+			values[o.ID] = o.Value
+			continue
+		}
+
+		value, err := l.evaluate(ctx, statute, o.program, request, normalizedRequest)
+		if err != nil {
+			var runErr *ErrExprRunFailed
+			if errors.As(err, &runErr) {
+				runErr.Err = fmt.Errorf("obligation %q: %w", o.ID, runErr.Err)
+			}
+			return err
+		}
+		values[o.ID] = value
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package leges_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObligations(t *testing.T) {
+	policies := []leges.Policy{
+		{
+			ID:        "owners_can_view",
+			Condition: `object.owner_id == subject.id`,
+			Actions:   []string{"VIEW"},
+			Obligations: []leges.Obligation{
+				{ID: "log_level", Value: "debug"},
+			},
+		},
+		{
+			ID:        "staff_can_view",
+			Condition: `subject.staff == true`,
+			Actions:   []string{"VIEW"},
+			Obligations: []leges.Obligation{
+				{ID: "log_level", Value: "info"},
+				{ID: "mask_fields", Expression: `object.owner_id == subject.id ? [] : ["email"]`},
+			},
+			Advice: []leges.Obligation{
+				{ID: "reason", Expression: `"staff member " + subject.id`},
+			},
+		},
+		{
+			ID:        "nobody_can_view_secrets",
+			Condition: `object.secret == true`,
+			Actions:   []string{"VIEW"},
+			Effect:    leges.EffectDeny,
+			Obligations: []leges.Obligation{
+				{ID: "log_level", Value: "warn"},
+			},
+		},
+	}
+	rules := mustNewLeges(t, policies, nil)
+
+	testCases := []struct {
+		name                string
+		subject             leges.Attributes
+		object              leges.Attributes
+		expectedVerdict     leges.Verdict
+		expectedObligations map[string]interface{}
+		expectedAdvice      map[string]interface{}
+	}{
+		{
+			name:                "obligations of the first policy are used first",
+			subject:             leges.Attributes{"id": "user1", "staff": true},
+			object:              leges.Attributes{"owner_id": "user1"},
+			expectedVerdict:     leges.Permit,
+			expectedObligations: map[string]interface{}{"log_level": "debug", "mask_fields": []interface{}{}},
+			expectedAdvice:      map[string]interface{}{"reason": "staff member user1"},
+		},
+		{
+			name:                "expressions are evaluated against the request",
+			subject:             leges.Attributes{"id": "user2", "staff": true},
+			object:              leges.Attributes{"owner_id": "user1"},
+			expectedVerdict:     leges.Permit,
+			expectedObligations: map[string]interface{}{"log_level": "info", "mask_fields": []string{"email"}},
+			expectedAdvice:      map[string]interface{}{"reason": "staff member user2"},
+		},
+		{
+			name:                "only the obligations of policies with the effect of the verdict",
+			subject:             leges.Attributes{"id": "user1", "staff": true},
+			object:              leges.Attributes{"owner_id": "user1", "secret": true},
+			expectedVerdict:     leges.Deny,
+			expectedObligations: map[string]interface{}{"log_level": "warn"},
+		},
+		{
+			name:            "no obligations if not applicable",
+			subject:         leges.Attributes{"id": "user2"},
+			object:          leges.Attributes{"owner_id": "user1"},
+			expectedVerdict: leges.NotApplicable,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			request := leges.Request{Action: "VIEW", Subject: tt.subject, Object: tt.object}
+
+			decision := rules.Decide(request)
+			require.Empty(t, decision.Errors)
+			require.Equal(t, tt.expectedVerdict, decision.Verdict)
+			require.Equal(t, tt.expectedObligations, decision.Obligations)
+			require.Equal(t, tt.expectedAdvice, decision.Advice)
+
+			results := rules.MatchBatch([]leges.Request{request})
+			require.Equal(t, tt.expectedObligations, results[0].Obligations)
+			require.Equal(t, tt.expectedAdvice, results[0].Advice)
+
+			result := rules.MatchWithObligations(request)
+			require.NoError(t, result.Err)
+			require.Equal(t, tt.expectedVerdict == leges.Permit, result.Match)
+			require.Equal(t, tt.expectedObligations, result.Obligations)
+			require.Equal(t, tt.expectedAdvice, result.Advice)
+		})
+	}
+
+	t.Run("indeterminate if an obligation fails to evaluate", func(t *testing.T) {
+		rules := mustNewLeges(t, []leges.Policy{
+			{
+				ID:        "anyone_can_view",
+				Condition: `true`,
+				Actions:   []string{"VIEW"},
+				Obligations: []leges.Obligation{
+					{ID: "region", Expression: `subject.profile.region`},
+				},
+			},
+		}, nil)
+
+		decision := rules.Decide(leges.Request{Action: "VIEW", Subject: leges.Attributes{"id": "user1"}, Object: leges.Attributes{"type": "page"}})
+		require.Equal(t, leges.Indeterminate, decision.Verdict)
+		require.Nil(t, decision.Obligations)
+
+		var runErr *leges.ErrExprRunFailed
+		require.True(t, errors.As(decision.Errors[0], &runErr))
+		require.Equal(t, "anyone_can_view", runErr.Policy.ID)
+		require.Contains(t, runErr.Err.Error(), `obligation "region"`)
+	})
+
+	t.Run("match doesn't evaluate obligations", func(t *testing.T) {
+		rules := mustNewLeges(t, []leges.Policy{
+			{
+				ID:        "anyone_can_view",
+				Condition: `true`,
+				Actions:   []string{"VIEW"},
+				Obligations: []leges.Obligation{
+					{ID: "region", Expression: `subject.profile.region`},
+				},
+			},
+		}, nil)
+
+		request := leges.Request{Action: "VIEW", Subject: leges.Attributes{"id": "user1"}, Object: leges.Attributes{"type": "page"}}
+		ok, policy, err := rules.Match(request)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "anyone_can_view", policy.ID)
+
+		result := rules.MatchWithObligations(request)
+		require.False(t, result.Match)
+		require.Nil(t, result.Obligations)
+		require.Contains(t, result.Err.Error(), "failed to run expression")
+	})
+
+	t.Run("advice that fails to evaluate is reported", func(t *testing.T) {
+		rules := mustNewLeges(t, []leges.Policy{
+			{
+				ID:        "anyone_can_view",
+				Condition: `true`,
+				Actions:   []string{"VIEW"},
+				Advice: []leges.Obligation{
+					{ID: "region", Expression: `subject.profile.region`},
+				},
+			},
+		}, nil)
+
+		decision := rules.Decide(leges.Request{Action: "VIEW", Subject: leges.Attributes{"id": "user1"}, Object: leges.Attributes{"type": "page"}})
+		require.Equal(t, leges.Permit, decision.Verdict)
+		require.Len(t, decision.Errors, 1)
+	})
+
+	t.Run("expressions can call functions", func(t *testing.T) {
+		rules, err := leges.NewLeges([]leges.Policy{
+			{
+				ID:        "anyone_can_view",
+				Condition: `true`,
+				Actions:   []string{"VIEW"},
+				Obligations: []leges.Obligation{
+					{ID: "require_mfa", Expression: `not cidrContains("10.0.0.0/8", env.ip)`},
+				},
+			},
+		}, nil, leges.WithFunctions(leges.StandardLibrary()))
+		require.NoError(t, err)
+
+		decision := rules.Decide(leges.Request{
+			Action:      "VIEW",
+			Subject:     leges.Attributes{"id": "user1"},
+			Object:      leges.Attributes{"type": "page"},
+			Environment: leges.Attributes{"ip": "192.0.2.1"},
+		})
+		require.Equal(t, map[string]interface{}{"require_mfa": true}, decision.Obligations)
+	})
+
+	t.Run("err if an obligation is invalid", func(t *testing.T) {
+		for _, obligation := range []leges.Obligation{
+			{Value: "debug"},
+			{ID: "log_level", Value: "debug", Expression: `"debug"`},
+		} {
+			_, err := leges.NewLeges([]leges.Policy{
+				{
+					ID:          "anyone_can_view",
+					Condition:   `true`,
+					Actions:     []string{"VIEW"},
+					Obligations: []leges.Obligation{obligation},
+				},
+			}, nil)
+			require.True(t, errors.Is(err, leges.ErrInvalidObligation), "obligation %+v: %v", obligation, err)
+		}
+	})
+
+	t.Run("err if an expression fails to compile", func(t *testing.T) {
+		_, err := leges.NewLeges([]leges.Policy{
+			{
+				ID:        "anyone_can_view",
+				Condition: `true`,
+				Actions:   []string{"VIEW"},
+				Advice: []leges.Obligation{
+					{ID: "reason", Expression: `subject.id +`},
+				},
+			},
+		}, nil)
+		var compileErr *leges.ErrExprCompileFailed
+		require.True(t, errors.As(err, &compileErr))
+		require.Equal(t, "anyone_can_view", compileErr.Policy.ID)
+	})
+}
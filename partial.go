@@ -0,0 +1,424 @@
+package leges
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/ast"
+	"github.com/antonmedv/expr/compiler"
+	"github.com/antonmedv/expr/parser"
+	"github.com/antonmedv/expr/vm"
+)
+
+var ErrUnsupportedResidual = errors.New("expression can't be partially evaluated")
+
+// ObjectFilter partially evaluates the policies of action for subject, with
+// the object unknown, and combines them with the combining algorithm of l.
+// The result is a predicate over object.* that holds for the objects Match
+// would grant the action on. It can be translated to a database query with
+// SQL or MongoFilter.
+//
+// Conditions that use the object other than comparing its attributes with
+// known values fail with ErrUnsupportedResidual.
+func (l *Leges) ObjectFilter(subject Attributes, action string) (Residual, error) {
+	return l.ObjectFilterContext(context.Background(), Request{Subject: subject, Action: action})
+}
+
+// ObjectFilterContext is like ObjectFilter, but takes the subject, the action
+// and the environment from request, whose Object is ignored, and evaluates the
+// policies with ctx.
+func (l *Leges) ObjectFilterContext(ctx context.Context, request Request) (Residual, error) {
+	if len(request.Subject) == 0 {
+		return nil, ErrEmptySubjectAttrs
+	}
+	if request.Action == "" {
+		return nil, ErrEmptyAction
+	}
+
+	subject, err := l.resolveAll(ctx, "subject", request.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	env := l.normalizeRequest(ctx, Request{
+		Action:      request.Action,
+		Subject:     subject,
+		Environment: request.Environment,
+	})
+
+	var (
+		statutes  []cachedPolicy
+		residuals []Residual
+		requested = l.requestedAction(request.Action)
+	)
+	for _, statute := range l.cachedPolicies {
+		if !requested.appliesTo(statute.policy) {
+			continue
+		}
+
+		residual, err := l.partialEval(ctx, statute, env, "object")
+		if err != nil {
+			return nil, err
+		}
+
+		statutes = append(statutes, statute)
+		residuals = append(residuals, residual)
+	}
+
+	return l.combineResiduals(statutes, residuals), nil
+}
+
+// combineResiduals combines the residuals of policies like combiner combines
+// their effects
+func (l *Leges) combineResiduals(statutes []cachedPolicy, residuals []Residual) Residual {
+	switch l.algorithm {
+	case PermitOverrides:
+		var allowed Residual = Constant(false)
+		for i, statute := range statutes {
+			if statute.policy.allows() {
+				allowed = or(allowed, residuals[i])
+			}
+		}
+		return allowed
+	case FirstApplicable:
+		var result Residual = Constant(false)
+		for i := len(statutes) - 1; i >= 0; i-- {
+			if statutes[i].policy.allows() {
+				result = or(residuals[i], result)
+			} else {
+				result = and(not(residuals[i]), result)
+			}
+		}
+		return result
+	case OnlyOneApplicable:
+		var result Residual = Constant(false)
+		for i, statute := range statutes {
+			if !statute.policy.allows() {
+				continue
+			}
+			var others Residual = Constant(false)
+			for j := range statutes {
+				if j != i {
+					others = or(others, residuals[j])
+				}
+			}
+			result = or(result, and(residuals[i], not(others)))
+		}
+		return result
+	default:
+		var allowed, denied Residual = Constant(false), Constant(false)
+		for i, statute := range statutes {
+			if statute.policy.allows() {
+				allowed = or(allowed, residuals[i])
+			} else {
+				denied = or(denied, residuals[i])
+			}
+		}
+		return and(allowed, not(denied))
+	}
+}
+
+// partialEval evaluates the condition of a policy with every attribute known
+// except the ones under the identifier unknown. The known sub-expressions are
+// evaluated with ctx and the evaluation budget of l each.
+func (l *Leges) partialEval(ctx context.Context, statute cachedPolicy, env Attributes, unknown string) (Residual, error) {
+	e := &partialEvaluator{
+		leges:   l,
+		ctx:     ctx,
+		tree:    statute.tree,
+		env:     env,
+		unknown: unknown,
+	}
+
+	p, err := e.eval(statute.tree.Node)
+	if err == nil {
+		var residual Residual
+		residual, err = e.residual(statute.tree.Node, p)
+		if err == nil {
+			return residual, nil
+		}
+	}
+
+	return nil, fmt.Errorf("policy %q: %w", statute.policy.ID, err)
+}
+
+// partial is the partially evaluated value of a node: either a reference to
+// an unknown attribute, a boolean residual, or otherwise a known value
+type partial struct {
+	field    []string
+	residual Residual
+	value    interface{}
+}
+
+func (p partial) known() bool {
+	return p.field == nil && p.residual == nil
+}
+
+type partialEvaluator struct {
+	leges   *Leges
+	ctx     context.Context
+	tree    *parser.Tree
+	env     Attributes
+	unknown string
+}
+
+func (e *partialEvaluator) unsupported(node ast.Node) error {
+	return fmt.Errorf("%s: %w", formatNode(node), ErrUnsupportedResidual)
+}
+
+func (e *partialEvaluator) eval(node ast.Node) (partial, error) {
+	if !refersTo(node, e.unknown) {
+		value, err := e.run(node)
+		return partial{value: value}, err
+	}
+
+	switch n := node.(type) {
+	case *ast.IdentifierNode:
+		return partial{field: []string{n.Value}}, nil
+	case *ast.PropertyNode:
+		base, err := e.eval(n.Node)
+		if err != nil {
+			return partial{}, err
+		}
+		if base.field == nil {
+			return partial{}, e.unsupported(node)
+		}
+		return partial{field: appendPath(base.field, n.Property)}, nil
+	case *ast.IndexNode:
+		base, err := e.eval(n.Node)
+		if err != nil {
+			return partial{}, err
+		}
+		index, err := e.eval(n.Index)
+		if err != nil {
+			return partial{}, err
+		}
+		key, ok := index.value.(string)
+		if base.field == nil || !index.known() || !ok {
+			return partial{}, e.unsupported(node)
+		}
+		return partial{field: appendPath(base.field, key)}, nil
+	case *ast.UnaryNode:
+		if n.Operator != "not" && n.Operator != "!" {
+			return partial{}, e.unsupported(node)
+		}
+		operand, err := e.evalResidual(n.Node)
+		if err != nil {
+			return partial{}, err
+		}
+		return e.simplify(not(operand)), nil
+	case *ast.BinaryNode:
+		return e.evalBinary(n)
+	case *ast.FunctionNode:
+		if n.Name == "hasRole" && len(n.Arguments) == 2 {
+			return e.evalHasRole(n)
+		}
+	case *ast.ConditionalNode:
+		cond, err := e.evalResidual(n.Cond)
+		if err != nil {
+			return partial{}, err
+		}
+		switch cond {
+		case Constant(true):
+			return e.eval(n.Exp1)
+		case Constant(false):
+			return e.eval(n.Exp2)
+		}
+	}
+
+	return partial{}, e.unsupported(node)
+}
+
+func (e *partialEvaluator) evalBinary(n *ast.BinaryNode) (partial, error) {
+	switch n.Operator {
+	case "and", "&&", "or", "||":
+		left, err := e.evalResidual(n.Left)
+		if err != nil {
+			return partial{}, err
+		}
+		// short-circuit like the vm does, the right operand may fail to
+		// evaluate otherwise
+		if n.Operator == "and" || n.Operator == "&&" {
+			if left == Constant(false) {
+				return partial{value: false}, nil
+			}
+		} else if left == Constant(true) {
+			return partial{value: true}, nil
+		}
+
+		right, err := e.evalResidual(n.Right)
+		if err != nil {
+			return partial{}, err
+		}
+		if n.Operator == "and" || n.Operator == "&&" {
+			return e.simplify(and(left, right)), nil
+		}
+		return e.simplify(or(left, right)), nil
+	}
+
+	left, err := e.eval(n.Left)
+	if err != nil {
+		return partial{}, err
+	}
+	right, err := e.eval(n.Right)
+	if err != nil {
+		return partial{}, err
+	}
+
+	if left.known() && right.known() {
+		value, err := evalOperator(n.Operator, left.value, right.value)
+		return partial{value: value}, err
+	}
+
+	switch n.Operator {
+	case "==", "!=", "<", "<=", ">", ">=":
+		if left.field != nil && right.known() {
+			return partial{residual: Comparison{Field: left.field, Operator: n.Operator, Value: right.value}}, nil
+		}
+		if right.field != nil && left.known() {
+			return partial{residual: Comparison{Field: right.field, Operator: flipOperator(n.Operator), Value: left.value}}, nil
+		}
+	case "in", "not in":
+		var residual Residual
+		if left.field != nil && right.known() {
+			if values, ok := toSlice(right.value); ok {
+				residual = Comparison{Field: left.field, Operator: "in", Value: values}
+			}
+		}
+		if right.field != nil && left.known() {
+			residual = Comparison{Field: right.field, Operator: "contains", Value: left.value}
+		}
+		if residual != nil {
+			if n.Operator == "not in" {
+				residual = not(residual)
+			}
+			return partial{residual: residual}, nil
+		}
+	}
+
+	return partial{}, e.unsupported(n)
+}
+
+// evalHasRole turns hasRole(x, role), where x is unknown, into a residual
+// that holds if one of the roles of x implies role
+func (e *partialEvaluator) evalHasRole(n *ast.FunctionNode) (partial, error) {
+	subject, err := e.eval(n.Arguments[0])
+	if err != nil {
+		return partial{}, err
+	}
+	role, err := e.eval(n.Arguments[1])
+	if err != nil {
+		return partial{}, err
+	}
+	name, ok := role.value.(string)
+	if subject.field == nil || !role.known() || !ok {
+		return partial{}, e.unsupported(n)
+	}
+
+	holders := e.leges.rolesImplying(name)
+	values := make([]interface{}, len(holders))
+	for i, holder := range holders {
+		values[i] = holder
+	}
+
+	var residual Residual = Comparison{Field: appendPath(subject.field, "role"), Operator: "in", Value: values}
+	for _, holder := range holders {
+		residual = or(residual, Comparison{Field: appendPath(subject.field, "roles"), Operator: "contains", Value: holder})
+	}
+	return partial{residual: residual}, nil
+}
+
+// evalResidual evaluates a node used as a boolean
+func (e *partialEvaluator) evalResidual(node ast.Node) (Residual, error) {
+	p, err := e.eval(node)
+	if err != nil {
+		return nil, err
+	}
+	return e.residual(node, p)
+}
+
+func (e *partialEvaluator) residual(node ast.Node, p partial) (Residual, error) {
+	switch {
+	case p.residual != nil:
+		return p.residual, nil
+	case p.field != nil:
+		return Comparison{Field: p.field, Operator: "==", Value: true}, nil
+	}
+
+	value, ok := p.value.(bool)
+	if !ok {
+		return nil, fmt.Errorf("%s is %T, expected bool: %w", formatNode(node), p.value, ErrUnsupportedResidual)
+	}
+	return Constant(value), nil
+}
+
+// simplify turns constant residuals into known values
+func (e *partialEvaluator) simplify(residual Residual) partial {
+	if constant, ok := residual.(Constant); ok {
+		return partial{value: bool(constant)}
+	}
+	return partial{residual: residual}
+}
+
+// run evaluates a node that does not refer to the unknown attributes
+func (e *partialEvaluator) run(node ast.Node) (interface{}, error) {
+	program, err := compiler.Compile(&parser.Tree{Node: node, Source: e.tree.Source}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if e.leges.evaluationTimeout > 0 {
+		return e.leges.runWithTimeout(e.ctx, program, e.env)
+	}
+	return vm.Run(program, e.env)
+}
+
+func appendPath(path []string, key string) []string {
+	return append(append([]string{}, path...), key)
+}
+
+func flipOperator(operator string) string {
+	switch operator {
+	case "<":
+		return ">"
+	case "<=":
+		return ">="
+	case ">":
+		return "<"
+	case ">=":
+		return "<="
+	}
+	return operator
+}
+
+func toSlice(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case []string:
+		values := make([]interface{}, len(v))
+		for i, element := range v {
+			values[i] = element
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+var operatorPrograms sync.Map
+
+// evalOperator applies a binary operator to two known values with the
+// semantics of expr
+func evalOperator(operator string, a, b interface{}) (interface{}, error) {
+	program, ok := operatorPrograms.Load(operator)
+	if !ok {
+		compiled, err := expr.Compile("a " + operator + " b")
+		if err != nil {
+			return nil, err
+		}
+		program, _ = operatorPrograms.LoadOrStore(operator, compiled)
+	}
+	return expr.Run(program.(*vm.Program), Attributes{"a": a, "b": b})
+}
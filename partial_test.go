@@ -0,0 +1,267 @@
+package leges_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObjectFilter(t *testing.T) {
+	policies := []leges.Policy{
+		{
+			ID: "admin_can_view_pages",
+			Condition: `
+				subject.role == "admin"
+				and object.type in ["page", "adminpage"]
+			`,
+			Actions: []string{"VIEW"},
+		},
+		{
+			ID:        "owner_can_view",
+			Condition: `object.owner_id == subject.id`,
+			Actions:   []string{"VIEW"},
+		},
+		{
+			ID:        "members_can_view",
+			Condition: `subject.id in object.members and object.size <= max_size`,
+			Actions:   []string{"VIEW"},
+		},
+		{
+			ID:        "nobody_can_view_archived",
+			Condition: `object.archived`,
+			Actions:   []string{"VIEW"},
+			Effect:    leges.EffectDeny,
+		},
+		{
+			ID:        "guest_can_signup",
+			Condition: `subject.role == "guest"`,
+			Actions:   []string{"SIGNUP"},
+		},
+	}
+
+	rules := mustNewLeges(t, policies, leges.Attributes{"max_size": 100})
+
+	testCases := []struct {
+		name     string
+		subject  leges.Attributes
+		action   string
+		expected string
+	}{
+		{
+			name:     "admin",
+			subject:  leges.Attributes{"id": "user1", "role": "admin"},
+			action:   "VIEW",
+			expected: `(object.type in ["page", "adminpage"] or object.owner_id == "user1" or ("user1" in object.members and object.size <= 100)) and not (object.archived == true)`,
+		},
+		{
+			name:     "editor",
+			subject:  leges.Attributes{"id": "user2", "role": "editor"},
+			action:   "VIEW",
+			expected: `(object.owner_id == "user2" or ("user2" in object.members and object.size <= 100)) and not (object.archived == true)`,
+		},
+		{
+			name:     "guest",
+			subject:  leges.Attributes{"id": "user3", "role": "guest"},
+			action:   "SIGNUP",
+			expected: `true`,
+		},
+		{
+			name:     "no applicable policy",
+			subject:  leges.Attributes{"id": "user1", "role": "admin"},
+			action:   "DELETE",
+			expected: `false`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			residual, err := rules.ObjectFilter(tc.subject, tc.action)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, residual.String())
+		})
+	}
+
+	t.Run("residual structure", func(t *testing.T) {
+		residual, err := rules.ObjectFilter(leges.Attributes{"id": "user2", "role": "editor"}, "VIEW")
+		require.NoError(t, err)
+		require.Equal(t, leges.And{
+			leges.Or{
+				leges.Comparison{Field: []string{"object", "owner_id"}, Operator: "==", Value: "user2"},
+				leges.And{
+					leges.Comparison{Field: []string{"object", "members"}, Operator: "contains", Value: "user2"},
+					leges.Comparison{Field: []string{"object", "size"}, Operator: "<=", Value: 100},
+				},
+			},
+			leges.Not{Residual: leges.Comparison{Field: []string{"object", "archived"}, Operator: "==", Value: true}},
+		}, residual)
+	})
+
+	t.Run("use the environment of the request", func(t *testing.T) {
+		rules := mustNewLeges(t, []leges.Policy{
+			{
+				ID:        "anyone_can_view_public_pages_in_the_office",
+				Condition: `object.public == true and env.office == true`,
+				Actions:   []string{"VIEW"},
+			},
+		}, leges.Attributes{"office": false})
+
+		residual, err := rules.ObjectFilterContext(context.Background(), leges.Request{
+			Action:      "VIEW",
+			Subject:     leges.Attributes{"id": "user1"},
+			Environment: leges.Attributes{"office": true},
+		})
+		require.NoError(t, err)
+		require.Equal(t, `object.public == true`, residual.String())
+
+		residual, err = rules.ObjectFilter(leges.Attributes{"id": "user1"}, "VIEW")
+		require.NoError(t, err)
+		require.Equal(t, leges.Constant(false), residual)
+	})
+
+	t.Run("error if subject is empty", func(t *testing.T) {
+		_, err := rules.ObjectFilter(nil, "VIEW")
+		require.True(t, errors.Is(err, leges.ErrEmptySubjectAttrs))
+	})
+}
+
+func TestObjectFilterAgreesWithMatch(t *testing.T) {
+	policies := []leges.Policy{
+		{
+			ID:        "editors_can_edit_pages",
+			Condition: `subject.role == "editor" and object.type == "page"`,
+			Actions:   []string{"EDIT"},
+		},
+		{
+			ID:        "nobody_can_edit_locked",
+			Condition: `object.locked == true`,
+			Actions:   []string{"EDIT"},
+			Effect:    leges.EffectDeny,
+		},
+		{
+			ID:        "owner_can_edit",
+			Condition: `object.owner_id == subject.id`,
+			Actions:   []string{"EDIT"},
+		},
+	}
+
+	subject := leges.Attributes{"id": "user1", "role": "editor"}
+	objects := []leges.Attributes{
+		{"type": "page", "locked": false, "owner_id": "user2"},
+		{"type": "page", "locked": true, "owner_id": "user1"},
+		{"type": "post", "locked": false, "owner_id": "user1"},
+		{"type": "post", "locked": false, "owner_id": "user2"},
+	}
+
+	algorithms := []leges.CombiningAlgorithm{
+		leges.DenyOverrides,
+		leges.PermitOverrides,
+		leges.FirstApplicable,
+	}
+
+	for _, algorithm := range algorithms {
+		t.Run(string(algorithm), func(t *testing.T) {
+			rules, err := leges.NewLeges(policies, nil, leges.WithCombiningAlgorithm(algorithm))
+			require.NoError(t, err)
+
+			residual, err := rules.ObjectFilter(subject, "EDIT")
+			require.NoError(t, err)
+
+			// the residual is also a valid condition with the same meaning
+			formatted := mustNewLeges(t, []leges.Policy{
+				{ID: "residual", Condition: residual.String(), Actions: []string{"EDIT"}},
+			}, nil)
+
+			for _, object := range objects {
+				expected, _, err := rules.Match(leges.Request{Action: "EDIT", Subject: subject, Object: object})
+				require.NoError(t, err)
+				require.Equal(t, expected, evalResidual(residual, object), "%s on %v", residual, object)
+
+				ok, _, err := formatted.Match(leges.Request{Action: "EDIT", Subject: subject, Object: object})
+				require.NoError(t, err)
+				require.Equal(t, expected, ok, "%s on %v", residual, object)
+			}
+		})
+	}
+}
+
+func TestObjectFilterUnsupported(t *testing.T) {
+	testCases := []string{
+		`object.owner_id == object.creator_id`,
+		`len(object.members) > 2`,
+		`object.name startsWith "a"`,
+	}
+
+	for _, condition := range testCases {
+		t.Run(condition, func(t *testing.T) {
+			rules := mustNewLeges(t, []leges.Policy{
+				{ID: "policy1", Condition: condition, Actions: []string{"VIEW"}},
+			}, nil)
+
+			_, err := rules.ObjectFilter(leges.Attributes{"id": "user1"}, "VIEW")
+			require.True(t, errors.Is(err, leges.ErrUnsupportedResidual), "%v", err)
+		})
+	}
+}
+
+// evalResidual evaluates a residual of scalar comparisons against an object
+func evalResidual(residual leges.Residual, object leges.Attributes) bool {
+	switch r := residual.(type) {
+	case leges.Constant:
+		return bool(r)
+	case leges.And:
+		for _, operand := range r {
+			if !evalResidual(operand, object) {
+				return false
+			}
+		}
+		return true
+	case leges.Or:
+		for _, operand := range r {
+			if evalResidual(operand, object) {
+				return true
+			}
+		}
+		return false
+	case leges.Not:
+		return !evalResidual(r.Residual, object)
+	case leges.Comparison:
+		value := object[r.Field[1]]
+		switch r.Operator {
+		case "==":
+			return value == r.Value
+		case "!=":
+			return value != r.Value
+		}
+	}
+	panic("unsupported residual " + residual.String())
+}
+
+func TestResidualString(t *testing.T) {
+	residual := leges.And{
+		leges.Not{Residual: leges.Comparison{Field: []string{"object", "type"}, Operator: "==", Value: "draft"}},
+		leges.Not{Residual: leges.Or{
+			leges.Comparison{Field: []string{"object", "size"}, Operator: ">", Value: 100},
+			leges.Comparison{Field: []string{"object", "tags"}, Operator: "contains", Value: "secret"},
+		}},
+	}
+	require.Equal(t, `not (object.type == "draft") and not (object.size > 100 or "secret" in object.tags)`, residual.String())
+
+	rules := mustNewLeges(t, []leges.Policy{
+		{ID: "residual", Condition: residual.String(), Actions: []string{"VIEW"}},
+	}, nil)
+	for _, tt := range []struct {
+		object   leges.Attributes
+		expected bool
+	}{
+		{leges.Attributes{"type": "page", "size": 1, "tags": []interface{}{}}, true},
+		{leges.Attributes{"type": "draft", "size": 1, "tags": []interface{}{}}, false},
+		{leges.Attributes{"type": "page", "size": 1, "tags": []interface{}{"secret"}}, false},
+	} {
+		ok, _, err := rules.Match(leges.Request{Action: "VIEW", Subject: leges.Attributes{"id": "user1"}, Object: tt.object})
+		require.NoError(t, err)
+		require.Equal(t, tt.expected, ok, "%v", tt.object)
+	}
+}
@@ -2,11 +2,29 @@ package leges
 
 import (
 	"errors"
+	"fmt"
 	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/checker"
+	"github.com/antonmedv/expr/conf"
+	"github.com/antonmedv/expr/parser"
 	"github.com/antonmedv/expr/vm"
+	"reflect"
 )
 
-var ErrEmptyPolicyID = errors.New("policy with empty id")
+var (
+	ErrEmptyPolicyID = errors.New("policy with empty id")
+	ErrInvalidEffect = errors.New("invalid policy effect")
+)
+
+// Effect is the outcome of a policy when its condition holds.
+type Effect string
+
+const (
+	// EffectAllow grants the request. It is the default effect.
+	EffectAllow Effect = "allow"
+	// EffectDeny refuses the request.
+	EffectDeny Effect = "deny"
+)
 
 // Policy defines a condition that is allowed.
 type Policy struct {
@@ -17,17 +35,109 @@ type Policy struct {
 	Condition string
 	// Actions is a list of actions allowed for this policy. For example,
 	// []string{"GET", "SET"} means that this policy allows both GET and
-	// SET actions.
+	// SET actions. An action may be a pattern in which "*" matches any
+	// sequence of characters, such as "page:*" or "*".
 	Actions []string
+	// Effect is either EffectAllow or EffectDeny. An empty effect is
+	// treated as EffectAllow.
+	Effect Effect
+	// Priority decides the order in which policies are evaluated. Policies
+	// with a higher priority are evaluated, and reported by Match, first.
+	// Policies with the same priority are evaluated in the order they were
+	// given to NewLeges.
+	Priority int
+	// Obligations are what the caller has to do when the policy decides a
+	// request, see Decision.Obligations
+	Obligations []Obligation
+	// Advice is like Obligations, but the caller may ignore it
+	Advice []Obligation
+	// Fields are the fields of the object that an allow policy makes
+	// visible, see FilterAttributes. Nested fields are separated by dots,
+	// and "*" matches any sequence of characters within a field name, as in
+	// "address.*" or "billing_*". An allow policy without Fields makes
+	// every field visible. Deny policies can't have Fields.
+	Fields []string
 }
 
 func (p Policy) Validate() error {
 	if p.ID == "" {
 		return ErrEmptyPolicyID
 	}
+	switch p.Effect {
+	case "", EffectAllow, EffectDeny:
+	default:
+		return fmt.Errorf("id=%q effect=%q: %w", p.ID, p.Effect, ErrInvalidEffect)
+	}
+	for _, o := range append(append([]Obligation{}, p.Obligations...), p.Advice...) {
+		if err := o.validate(p.ID); err != nil {
+			return err
+		}
+	}
+	for _, field := range p.Fields {
+		if err := validateField(p, field); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (p Policy) compileCondition() (*vm.Program, error) {
-	return expr.Compile(p.Condition)
+// allows reports whether the policy grants the request when its condition holds.
+func (p Policy) allows() bool {
+	return p.Effect != EffectDeny
+}
+
+// ErrNonBooleanCondition is returned when the condition of a policy does not
+// evaluate to a boolean. It is returned by NewLeges if the type of the
+// condition is known when it is compiled, otherwise by Match.
+type ErrNonBooleanCondition struct {
+	PolicyID string
+	// Type is the type of the result of the condition
+	Type string
+}
+
+func (e *ErrNonBooleanCondition) Error() string {
+	return fmt.Sprintf("condition of policy %q returns %s, expected bool", e.PolicyID, e.Type)
+}
+
+// compileCondition compiles the condition and returns the program along with
+// the parsed tree of the condition
+func (p Policy) compileCondition(opts ...expr.Option) (*vm.Program, *parser.Tree, error) {
+	tree, err := parser.Parse(p.Condition)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// check with the same types that expr.Compile uses
+	config := &conf.Config{
+		Operators:    conf.OperatorsTable{},
+		ConstExprFns: map[string]reflect.Value{},
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	t, err := checker.Check(tree, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the type of nil conditions, such as `nil`, is nil
+	if t == nil {
+		return nil, nil, &ErrNonBooleanCondition{
+			PolicyID: p.ID,
+			Type:     "nil",
+		}
+	}
+	if t.Kind() != reflect.Bool && t.Kind() != reflect.Interface {
+		return nil, nil, &ErrNonBooleanCondition{
+			PolicyID: p.ID,
+			Type:     t.String(),
+		}
+	}
+
+	program, err := expr.Compile(p.Condition, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return program, tree, nil
 }
@@ -1,6 +1,7 @@
 package leges_test
 
 import (
+	"errors"
 	"github.com/siadat/leges"
 	"github.com/stretchr/testify/require"
 	"testing"
@@ -18,6 +19,18 @@ func TestPolicy_Validate(t *testing.T) {
 		require.Error(t, err, leges.ErrEmptyPolicyID)
 	})
 
+	t.Run("unknown effect - invalid policy", func(t *testing.T) {
+		p := leges.Policy{
+			ID:        "id",
+			Condition: "subject.is_admin == true",
+			Actions:   []string{"action"},
+			Effect:    "maybe",
+		}
+
+		err := p.Validate()
+		require.True(t, errors.Is(err, leges.ErrInvalidEffect))
+	})
+
 	t.Run("valid policy", func(t *testing.T) {
 		p := leges.Policy{
 			ID:        "id",
@@ -0,0 +1,203 @@
+package leges
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/antonmedv/expr/ast"
+)
+
+var ErrInvalidAttributeProvider = errors.New("invalid attribute provider")
+
+const (
+	// attributeFunction is the function references to provided attributes
+	// are rewritten to. It is not an identifier, so conditions can't call it.
+	attributeFunction = "leges:attribute"
+	// attributeResolverKey holds the attributeResolver of a normalized
+	// request
+	attributeResolverKey = "leges:resolver"
+)
+
+// AttributeProvider resolves an attribute that callers don't put in requests,
+// such as the groups of a subject kept in a directory.
+type AttributeProvider interface {
+	// Attribute returns the value of the attribute name of a subject or an
+	// object, given its attributes in the request
+	Attribute(ctx context.Context, attributes Attributes, name string) (interface{}, error)
+}
+
+// AttributeProviderFunc is an AttributeProvider implemented by a function
+type AttributeProviderFunc func(ctx context.Context, attributes Attributes, name string) (interface{}, error)
+
+func (f AttributeProviderFunc) Attribute(ctx context.Context, attributes Attributes, name string) (interface{}, error) {
+	return f(ctx, attributes, name)
+}
+
+// WithAttributeProvider registers a provider of an attribute of the subject
+// or the object, such as "subject.groups". The provider is called when a
+// condition first uses the attribute while matching a request, unless the
+// request already has it. Its result is reused for the rest of the request.
+// If it fails, the condition fails to evaluate with its error.
+func WithAttributeProvider(attribute string, provider AttributeProvider) Option {
+	return func(l *Leges) error {
+		path := strings.Split(attribute, ".")
+		if len(path) != 2 || (path[0] != "subject" && path[0] != "object") || path[1] == "" || provider == nil {
+			return fmt.Errorf("attribute=%q: %w", attribute, ErrInvalidAttributeProvider)
+		}
+
+		if l.providers == nil {
+			l.providers = map[string]AttributeProvider{}
+		}
+		l.providers[attribute] = provider
+		return nil
+	}
+}
+
+// provides reports whether an attribute path starts with a provided
+// attribute
+func (l *Leges) provides(path []string) bool {
+	if len(path) < 2 {
+		return false
+	}
+	_, ok := l.providers[path[0]+"."+path[1]]
+	return ok
+}
+
+// attributePatcher rewrites references to provided attributes, such as
+// subject.groups, to calls of attributeFunction, such as
+// leges:attribute("subject", "groups")
+type attributePatcher struct {
+	leges *Leges
+}
+
+func (p attributePatcher) Enter(node *ast.Node) {}
+
+func (p attributePatcher) Exit(node *ast.Node) {
+	path, ok := attributePath(*node)
+	if !ok || len(path) != 2 || !p.leges.provides(path) {
+		return
+	}
+
+	ast.Patch(node, &ast.FunctionNode{
+		Name: attributeFunction,
+		Arguments: []ast.Node{
+			&ast.StringNode{Value: path[0]},
+			&ast.StringNode{Value: path[1]},
+		},
+	})
+}
+
+// attributeResolver resolves the provided attributes of a single request
+type attributeResolver struct {
+	ctx       context.Context
+	providers map[string]AttributeProvider
+	request   Request
+
+	mu       sync.Mutex
+	resolved map[string]resolvedAttribute
+}
+
+type resolvedAttribute struct {
+	value interface{}
+	err   error
+}
+
+// attribute is attributeFunction. Since functions can't return errors to the
+// vm, it panics with the error of the provider, which the vm turns into an
+// evaluation error.
+func (r *attributeResolver) attribute(root, name string) interface{} {
+	attributes := r.request.Subject
+	if root == "object" {
+		attributes = r.request.Object
+	}
+	if value, ok := attributes[name]; ok {
+		return value
+	}
+
+	key := root + "." + name
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	resolved, ok := r.resolved[key]
+	if !ok {
+		resolved.value, resolved.err = r.providers[key].Attribute(r.ctx, attributes, name)
+		r.resolved[key] = resolved
+	}
+	if resolved.err != nil {
+		panic(fmt.Errorf("resolving %s: %w", key, resolved.err))
+	}
+	return resolved.value
+}
+
+// resolvedEnv returns a copy of a normalized request in which the subject and
+// the object include the attributes resolved so far
+func resolvedEnv(env Attributes) Attributes {
+	r, ok := env[attributeResolverKey].(*attributeResolver)
+	if !ok {
+		return env
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.resolved) == 0 {
+		return env
+	}
+
+	overlaid := Attributes{}
+	for k, v := range env {
+		overlaid[k] = v
+	}
+	for key, resolved := range r.resolved {
+		if resolved.err != nil {
+			continue
+		}
+		path := strings.SplitN(key, ".", 2)
+		attributes, _ := overlaid[path[0]].(Attributes)
+		copied := Attributes{}
+		for k, v := range attributes {
+			copied[k] = v
+		}
+		copied[path[1]] = resolved.value
+		overlaid[path[0]] = copied
+	}
+	return overlaid
+}
+
+// resolveAll returns a copy of the attributes of root, "subject" or "object",
+// with every provided attribute of root that is missing resolved
+func (l *Leges) resolveAll(ctx context.Context, root string, attributes Attributes) (Attributes, error) {
+	var keys []string
+	for key := range l.providers {
+		if strings.HasPrefix(key, root+".") {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return attributes, nil
+	}
+	sort.Strings(keys)
+
+	resolved := Attributes{}
+	for k, v := range attributes {
+		resolved[k] = v
+	}
+	for _, key := range keys {
+		name := key[len(root)+1:]
+		if _, ok := resolved[name]; ok {
+			continue
+		}
+
+		value, err := l.providers[key].Attribute(ctx, attributes, name)
+		if err != nil {
+			return nil, fmt.Errorf("resolving %s: %w", key, err)
+		}
+		resolved[name] = value
+	}
+	return resolved, nil
+}
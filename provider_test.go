@@ -0,0 +1,174 @@
+package leges_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+type contextKey string
+
+func TestAttributeProvider(t *testing.T) {
+	var calls int64
+	groups := leges.AttributeProviderFunc(func(ctx context.Context, subject leges.Attributes, name string) (interface{}, error) {
+		atomic.AddInt64(&calls, 1)
+		if ctx.Value(contextKey("tenant")) != "acme" {
+			return nil, errors.New("unknown tenant")
+		}
+		switch subject["id"] {
+		case "user1":
+			return []interface{}{"eng", "admins"}, nil
+		case "user2":
+			return []interface{}{"sales"}, nil
+		case "offline":
+			return nil, errors.New("directory unavailable")
+		}
+		return nil, nil
+	})
+
+	rules, err := leges.NewLeges([]leges.Policy{
+		{
+			ID:        "engineers_can_deploy",
+			Condition: `"eng" in subject.groups`,
+			Actions:   []string{"DEPLOY"},
+		},
+		{
+			ID:        "admins_can_deploy_anything",
+			Condition: `"admins" in subject.groups and object.env == "prod"`,
+			Actions:   []string{"DEPLOY"},
+		},
+		{
+			ID:        "guests_can_view",
+			Condition: `subject.role == "guest" or "viewers" in subject.groups`,
+			Actions:   []string{"VIEW"},
+		},
+		{
+			ID:        "sales_can_view_reports",
+			Condition: `object.type == "report" and "sales" in subject.groups`,
+			Actions:   []string{"VIEW"},
+		},
+	}, nil, leges.WithAttributeProvider("subject.groups", groups))
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), contextKey("tenant"), "acme")
+	match := func(ctx context.Context, subject leges.Attributes, action string) (bool, error) {
+		ok, _, err := rules.MatchContext(ctx, leges.Request{
+			Action:  action,
+			Subject: subject,
+			Object:  leges.Attributes{"env": "prod", "type": "page"},
+		})
+		return ok, err
+	}
+
+	t.Run("resolved once per request", func(t *testing.T) {
+		atomic.StoreInt64(&calls, 0)
+		ok, err := match(ctx, leges.Attributes{"id": "user1"}, "DEPLOY")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, int64(1), atomic.LoadInt64(&calls))
+
+		ok, err = match(ctx, leges.Attributes{"id": "user2"}, "DEPLOY")
+		require.NoError(t, err)
+		require.False(t, ok)
+		require.Equal(t, int64(2), atomic.LoadInt64(&calls))
+	})
+
+	t.Run("not resolved if not used", func(t *testing.T) {
+		atomic.StoreInt64(&calls, 0)
+		ok, err := match(ctx, leges.Attributes{"id": "user3", "role": "guest"}, "VIEW")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, int64(0), atomic.LoadInt64(&calls))
+	})
+
+	t.Run("not resolved if in the request", func(t *testing.T) {
+		atomic.StoreInt64(&calls, 0)
+		ok, err := match(ctx, leges.Attributes{"id": "user3", "groups": []interface{}{"eng"}}, "DEPLOY")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, int64(0), atomic.LoadInt64(&calls))
+	})
+
+	t.Run("errors fail the evaluation", func(t *testing.T) {
+		_, err := match(ctx, leges.Attributes{"id": "offline", "role": "editor"}, "VIEW")
+		var runErr *leges.ErrExprRunFailed
+		require.True(t, errors.As(err, &runErr))
+		require.Equal(t, "guests_can_view", runErr.Policy.ID)
+		require.Contains(t, runErr.Err.Error(), "directory unavailable")
+	})
+
+	t.Run("context is passed to the provider", func(t *testing.T) {
+		_, err := match(context.Background(), leges.Attributes{"id": "user1"}, "DEPLOY")
+		require.Error(t, err)
+		require.Contains(t, err.(*leges.ErrExprRunFailed).Err.Error(), "unknown tenant")
+	})
+
+	t.Run("explain shows resolved attributes", func(t *testing.T) {
+		rules, err := leges.NewLeges([]leges.Policy{
+			{
+				ID:        "engineers_can_deploy",
+				Condition: `"eng" in subject.groups`,
+				Actions:   []string{"DEPLOY"},
+			},
+		}, nil, leges.WithAttributeProvider("subject.groups", leges.AttributeProviderFunc(func(ctx context.Context, subject leges.Attributes, name string) (interface{}, error) {
+			return []interface{}{"sales"}, nil
+		})))
+		require.NoError(t, err)
+
+		explanation, err := rules.Explain(leges.Request{
+			Action:  "DEPLOY",
+			Subject: leges.Attributes{"id": "user2"},
+			Object:  leges.Attributes{"env": "prod"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []leges.ExpressionTrace{
+			{
+				Expression: `"eng" in subject.groups`,
+				Values:     map[string]interface{}{"subject.groups": []interface{}{"sales"}},
+			},
+		}, explanation.Policies[0].FalseExpressions)
+
+		residual, err := rules.ObjectFilter(leges.Attributes{"id": "user2"}, "DEPLOY")
+		require.NoError(t, err)
+		require.Equal(t, leges.Constant(false), residual)
+	})
+}
+
+func TestProvidedAttributesAreNotGuards(t *testing.T) {
+	var calls int64
+	rules, err := leges.NewLeges([]leges.Policy{
+		{
+			ID:        "sales_can_view_reports",
+			Condition: `subject.department == "sales" and object.type == "report"`,
+			Actions:   []string{"VIEW"},
+		},
+	}, nil, leges.WithAttributeProvider("subject.department", leges.AttributeProviderFunc(func(ctx context.Context, subject leges.Attributes, name string) (interface{}, error) {
+		atomic.AddInt64(&calls, 1)
+		return "sales", nil
+	})))
+	require.NoError(t, err)
+
+	ok, _, err := rules.Match(leges.Request{
+		Action:  "VIEW",
+		Subject: leges.Attributes{"id": "user1"},
+		Object:  leges.Attributes{"type": "report"},
+	})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(1), atomic.LoadInt64(&calls))
+}
+
+func TestInvalidAttributeProvider(t *testing.T) {
+	provider := leges.AttributeProviderFunc(func(ctx context.Context, attributes leges.Attributes, name string) (interface{}, error) {
+		return nil, nil
+	})
+
+	for _, attribute := range []string{"groups", "env.time", "subject.", "subject.groups.name"} {
+		_, err := leges.NewLeges(nil, nil, leges.WithAttributeProvider(attribute, provider))
+		require.True(t, errors.Is(err, leges.ErrInvalidAttributeProvider), attribute)
+	}
+}
@@ -0,0 +1,138 @@
+package leges
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+var ErrInvalidRole = errors.New("invalid role")
+
+// Role is a named set of permissions. A role inherits the permissions of the
+// roles it inherits, directly or transitively.
+type Role struct {
+	Name string
+	// Inherits are the names of the roles whose permissions this role has
+	Inherits []string
+	// Permissions are the actions granted to subjects with this role
+	Permissions []Permission
+}
+
+// Permission grants actions to the subjects of a role. If Condition is not
+// empty, the actions are only granted when it holds too.
+type Permission struct {
+	Actions   []string
+	Condition string
+}
+
+// WithRoles adds role-based access control. Each permission of a role
+// becomes an allow policy with the id "role:<name>:<index>", and the
+// hasRole(subject, name) function becomes available to conditions.
+//
+// The roles of a subject are its "role" attribute, a string, and its "roles"
+// attribute, a list of strings. A subject has a role if one of its roles is,
+// or inherits, that role.
+func WithRoles(roles []Role) Option {
+	return func(l *Leges) error {
+		inherits := make(map[string][]string, len(roles))
+		for _, role := range roles {
+			if role.Name == "" {
+				return fmt.Errorf("empty role name: %w", ErrInvalidRole)
+			}
+			if _, ok := inherits[role.Name]; ok {
+				return fmt.Errorf("name=%q is duplicate: %w", role.Name, ErrInvalidRole)
+			}
+			inherits[role.Name] = role.Inherits
+		}
+
+		for _, role := range roles {
+			for _, inherited := range role.Inherits {
+				if _, ok := inherits[inherited]; !ok {
+					return fmt.Errorf("name=%q inherits undeclared role %q: %w", role.Name, inherited, ErrInvalidRole)
+				}
+			}
+		}
+
+		l.roles = roles
+		l.inheritedRoles = make(map[string]map[string]bool, len(roles))
+		for _, role := range roles {
+			all := map[string]bool{}
+			for _, name := range closure(role.Name, inherits) {
+				all[name] = true
+			}
+			l.inheritedRoles[role.Name] = all
+		}
+		return nil
+	}
+}
+
+// rolePolicies returns the policies granting the permissions of the roles
+func (l *Leges) rolePolicies() []Policy {
+	var policies []Policy
+	for _, role := range l.roles {
+		for i, permission := range role.Permissions {
+			condition := "hasRole(subject, " + strconv.Quote(role.Name) + ")"
+			if permission.Condition != "" {
+				condition += " and (" + permission.Condition + ")"
+			}
+
+			policies = append(policies, Policy{
+				ID:        fmt.Sprintf("role:%s:%d", role.Name, i),
+				Condition: condition,
+				Actions:   permission.Actions,
+			})
+		}
+	}
+	return policies
+}
+
+// hasRole reports whether subject has role, directly or by inheritance
+func (l *Leges) hasRole(subject interface{}, role string) bool {
+	attributes, ok := subject.(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	has := func(direct interface{}) bool {
+		name, ok := direct.(string)
+		if !ok {
+			return false
+		}
+		if name == role {
+			return true
+		}
+		return l.inheritedRoles[name][role]
+	}
+
+	if has(attributes["role"]) {
+		return true
+	}
+	switch roles := attributes["roles"].(type) {
+	case []interface{}:
+		for _, direct := range roles {
+			if has(direct) {
+				return true
+			}
+		}
+	case []string:
+		for _, direct := range roles {
+			if has(direct) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rolesImplying returns role and the roles inheriting it, sorted by name
+func (l *Leges) rolesImplying(role string) []string {
+	roles := []string{role}
+	for name, inherited := range l.inheritedRoles {
+		if name != role && inherited[role] {
+			roles = append(roles, name)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
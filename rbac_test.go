@@ -0,0 +1,143 @@
+package leges_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoles(t *testing.T) {
+	roles := []leges.Role{
+		{
+			Name:     "admin",
+			Inherits: []string{"editor"},
+			Permissions: []leges.Permission{
+				{Actions: []string{"DELETE"}},
+			},
+		},
+		{
+			Name:     "editor",
+			Inherits: []string{"viewer"},
+			Permissions: []leges.Permission{
+				{Actions: []string{"UPDATE"}, Condition: `object.type == "page"`},
+			},
+		},
+		{
+			Name: "viewer",
+			Permissions: []leges.Permission{
+				{Actions: []string{"VIEW"}},
+			},
+		},
+	}
+
+	policies := []leges.Policy{
+		{
+			ID:        "editors_can_publish",
+			Condition: `hasRole(subject, "editor") and not object.locked`,
+			Actions:   []string{"PUBLISH"},
+		},
+	}
+
+	rules, err := leges.NewLeges(policies, nil, leges.WithRoles(roles))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name             string
+		subject          leges.Attributes
+		action           string
+		expectedOk       bool
+		expectedPolicyID string
+	}{
+		{
+			name:             "direct permission",
+			subject:          leges.Attributes{"role": "viewer"},
+			action:           "VIEW",
+			expectedOk:       true,
+			expectedPolicyID: "role:viewer:0",
+		},
+		{
+			name:             "inherited permission",
+			subject:          leges.Attributes{"role": "admin"},
+			action:           "VIEW",
+			expectedOk:       true,
+			expectedPolicyID: "role:viewer:0",
+		},
+		{
+			name:             "permission with condition",
+			subject:          leges.Attributes{"role": "admin"},
+			action:           "UPDATE",
+			expectedOk:       true,
+			expectedPolicyID: "role:editor:0",
+		},
+		{
+			name:       "permission of inheriting role",
+			subject:    leges.Attributes{"role": "editor"},
+			action:     "DELETE",
+			expectedOk: false,
+		},
+		{
+			name:             "list of roles",
+			subject:          leges.Attributes{"roles": []interface{}{"guest", "admin"}},
+			action:           "PUBLISH",
+			expectedOk:       true,
+			expectedPolicyID: "editors_can_publish",
+		},
+		{
+			name:       "undeclared role",
+			subject:    leges.Attributes{"role": "guest"},
+			action:     "VIEW",
+			expectedOk: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ok, policy, err := rules.Match(leges.Request{
+				Action:  tc.action,
+				Subject: tc.subject,
+				Object:  leges.Attributes{"type": "page", "locked": false},
+			})
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOk, ok)
+			if tc.expectedOk {
+				require.Equal(t, tc.expectedPolicyID, policy.ID)
+			}
+		})
+	}
+
+	t.Run("subject constraints", func(t *testing.T) {
+		constraints, err := rules.SubjectConstraints(leges.Attributes{"type": "page", "locked": false}, "PUBLISH")
+		require.NoError(t, err)
+		require.Len(t, constraints, 1)
+		require.Equal(t, `subject.role in ["admin", "editor"] or "admin" in subject.roles or "editor" in subject.roles`, constraints[0].Residual.String())
+	})
+}
+
+func TestInvalidRoles(t *testing.T) {
+	testCases := []struct {
+		name  string
+		roles []leges.Role
+	}{
+		{
+			name:  "empty name",
+			roles: []leges.Role{{Name: ""}},
+		},
+		{
+			name:  "duplicate name",
+			roles: []leges.Role{{Name: "admin"}, {Name: "admin"}},
+		},
+		{
+			name:  "undeclared inherited role",
+			roles: []leges.Role{{Name: "admin", Inherits: []string{"editor"}}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := leges.NewLeges(nil, nil, leges.WithRoles(tc.roles))
+			require.True(t, errors.Is(err, leges.ErrInvalidRole), "%v", err)
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package leges
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var ErrInvalidTuple = errors.New("invalid relationship tuple")
+
+// Tuple is a relationship between an object and a subject, written as
+// object#relation@subject, for example "folder:1#owner@user:1". The subject
+// may be a userset, object#relation, such as "group:eng#member", which
+// stands for every subject with that relation to that object.
+type Tuple struct {
+	Object   string
+	Relation string
+	Subject  string
+}
+
+// ParseTuple parses a tuple written as object#relation@subject
+func ParseTuple(s string) (Tuple, error) {
+	hash := strings.Index(s, "#")
+	if hash < 0 {
+		return Tuple{}, fmt.Errorf("tuple=%q: %w", s, ErrInvalidTuple)
+	}
+	at := strings.Index(s[hash:], "@")
+	if at < 0 {
+		return Tuple{}, fmt.Errorf("tuple=%q: %w", s, ErrInvalidTuple)
+	}
+
+	tuple := Tuple{
+		Object:   s[:hash],
+		Relation: s[hash+1 : hash+at],
+		Subject:  s[hash+at+1:],
+	}
+	if err := tuple.Validate(); err != nil {
+		return Tuple{}, err
+	}
+	return tuple, nil
+}
+
+func (t Tuple) Validate() error {
+	if t.Object == "" || t.Relation == "" || t.Subject == "" {
+		return fmt.Errorf("tuple=%q: %w", t.String(), ErrInvalidTuple)
+	}
+	return nil
+}
+
+func (t Tuple) String() string {
+	return t.Object + "#" + t.Relation + "@" + t.Subject
+}
+
+// TupleStore gives access to relationship tuples. Implementations must be
+// safe for concurrent use.
+type TupleStore interface {
+	// Subjects returns the subjects of the tuples with the given object and
+	// relation, including usersets
+	Subjects(ctx context.Context, object, relation string) ([]string, error)
+}
+
+// MemoryTupleStore is a TupleStore holding the tuples in memory
+type MemoryTupleStore struct {
+	mu       sync.RWMutex
+	subjects map[objectRelation][]string
+}
+
+type objectRelation struct {
+	object   string
+	relation string
+}
+
+// NewMemoryTupleStore returns a MemoryTupleStore holding tuples
+func NewMemoryTupleStore(tuples ...Tuple) (*MemoryTupleStore, error) {
+	store := &MemoryTupleStore{}
+	if err := store.Write(tuples...); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Write adds tuples to the store, tuples already in the store are ignored
+func (s *MemoryTupleStore) Write(tuples ...Tuple) error {
+	for _, tuple := range tuples {
+		if err := tuple.Validate(); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.subjects == nil {
+		s.subjects = map[objectRelation][]string{}
+	}
+	for _, tuple := range tuples {
+		key := objectRelation{tuple.Object, tuple.Relation}
+		if !sliceIncludes(s.subjects[key], tuple.Subject) {
+			s.subjects[key] = append(s.subjects[key], tuple.Subject)
+		}
+	}
+	return nil
+}
+
+// Delete removes tuples from the store
+func (s *MemoryTupleStore) Delete(tuples ...Tuple) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, tuple := range tuples {
+		key := objectRelation{tuple.Object, tuple.Relation}
+		subjects := s.subjects[key]
+		for i, subject := range subjects {
+			if subject == tuple.Subject {
+				// copy, readers may hold the old slice
+				s.subjects[key] = append(append([]string{}, subjects[:i]...), subjects[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (s *MemoryTupleStore) Subjects(ctx context.Context, object, relation string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.subjects[objectRelation{object, relation}], nil
+}
+
+// RelationRewrite derives a relation from other relations, like the userset
+// rewrites of Zanzibar
+type RelationRewrite struct {
+	// Relation is the relation being derived
+	Relation string
+	// ImpliedBy are relations whose subjects also have Relation to the same
+	// object. For example, if "viewer" is implied by "editor", the editors
+	// of a document are also its viewers.
+	ImpliedBy []string
+	// InheritedThrough are relations to other objects whose subjects with
+	// Relation also have Relation to the object. For example, if "viewer"
+	// is inherited through "parent", the viewers of doc:1#parent@folder:1
+	// include the viewers of folder:1.
+	InheritedThrough []string
+}
+
+// WithRelationships adds relationship-based access control backed by store.
+// The related(subject, relation, object) function becomes available to
+// conditions. It holds if the tuple object#relation@subject is in the
+// store, or follows from it through usersets and rewrites. For example
+// related(subject.id, "owner", object.folder_id).
+func WithRelationships(store TupleStore, rewrites ...RelationRewrite) Option {
+	return func(l *Leges) error {
+		l.tuples = store
+		l.rewrites = make(map[string]RelationRewrite, len(rewrites))
+		for _, rewrite := range rewrites {
+			if rewrite.Relation == "" {
+				return fmt.Errorf("rewrite of empty relation: %w", ErrInvalidTuple)
+			}
+			l.rewrites[rewrite.Relation] = rewrite
+		}
+		return nil
+	}
+}
+
+// relationChecker checks relationships for a single request. It memoizes
+// the result of each check, since conditions of many policies often check
+// the same relationship.
+type relationChecker struct {
+	ctx      context.Context
+	store    TupleStore
+	rewrites map[string]RelationRewrite
+
+	mu      sync.Mutex
+	checked map[Tuple]bool
+}
+
+// related is the related function of conditions. Since functions can't
+// return errors to the vm, it panics with the error of the store, which the
+// vm turns into an evaluation error.
+func (c *relationChecker) related(subject interface{}, relation string, object interface{}) bool {
+	if subject == nil || object == nil {
+		return false
+	}
+
+	tuple := Tuple{
+		Object:   fmt.Sprint(object),
+		Relation: relation,
+		Subject:  fmt.Sprint(subject),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ok, found := c.checked[tuple]; found {
+		return ok
+	}
+
+	ok, err := c.check(tuple, map[objectRelation]bool{})
+	if err != nil {
+		panic(fmt.Errorf("checking %s: %w", tuple, err))
+	}
+	c.checked[tuple] = ok
+	return ok
+}
+
+// check reports whether the relationship holds. visited holds the object
+// relations already being checked, to stop at cycles.
+func (c *relationChecker) check(tuple Tuple, visited map[objectRelation]bool) (bool, error) {
+	key := objectRelation{tuple.Object, tuple.Relation}
+	if visited[key] {
+		return false, nil
+	}
+	visited[key] = true
+
+	if err := c.ctx.Err(); err != nil {
+		return false, err
+	}
+
+	subjects, err := c.store.Subjects(c.ctx, tuple.Object, tuple.Relation)
+	if err != nil {
+		return false, err
+	}
+
+	for _, subject := range subjects {
+		if subject == tuple.Subject {
+			return true, nil
+		}
+	}
+
+	// usersets, such as group:eng#member
+	for _, subject := range subjects {
+		hash := strings.LastIndex(subject, "#")
+		if hash < 0 {
+			continue
+		}
+		ok, err := c.check(Tuple{Object: subject[:hash], Relation: subject[hash+1:], Subject: tuple.Subject}, visited)
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+
+	rewrite, ok := c.rewrites[tuple.Relation]
+	if !ok {
+		return false, nil
+	}
+
+	for _, relation := range rewrite.ImpliedBy {
+		ok, err := c.check(Tuple{Object: tuple.Object, Relation: relation, Subject: tuple.Subject}, visited)
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+
+	for _, through := range rewrite.InheritedThrough {
+		parents, err := c.store.Subjects(c.ctx, tuple.Object, through)
+		if err != nil {
+			return false, err
+		}
+		for _, parent := range parents {
+			ok, err := c.check(Tuple{Object: parent, Relation: tuple.Relation, Subject: tuple.Subject}, visited)
+			if ok || err != nil {
+				return ok, err
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func sliceIncludes(slice []string, needle string) bool {
+	for _, item := range slice {
+		if item == needle {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package leges_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTuple(t *testing.T) {
+	tuple, err := leges.ParseTuple("folder:1#viewer@group:eng#member")
+	require.NoError(t, err)
+	require.Equal(t, leges.Tuple{Object: "folder:1", Relation: "viewer", Subject: "group:eng#member"}, tuple)
+	require.Equal(t, "folder:1#viewer@group:eng#member", tuple.String())
+
+	for _, invalid := range []string{"", "folder:1", "folder:1#owner", "#owner@user:1", "folder:1#@user:1", "folder:1#owner@"} {
+		_, err := leges.ParseTuple(invalid)
+		require.True(t, errors.Is(err, leges.ErrInvalidTuple), invalid)
+	}
+}
+
+func TestRelationships(t *testing.T) {
+	var tuples []leges.Tuple
+	for _, s := range []string{
+		"folder:1#owner@user:1",
+		"folder:2#viewer@group:eng#member",
+		"group:eng#member@user:2",
+		"group:eng#member@group:interns#member",
+		"group:interns#member@user:3",
+		"doc:1#parent@folder:2",
+		"doc:2#editor@user:4",
+		"folder:3#parent@folder:4",
+		"folder:4#parent@folder:3",
+	} {
+		tuple, err := leges.ParseTuple(s)
+		require.NoError(t, err)
+		tuples = append(tuples, tuple)
+	}
+
+	store, err := leges.NewMemoryTupleStore(tuples...)
+	require.NoError(t, err)
+
+	rules, err := leges.NewLeges([]leges.Policy{
+		{
+			ID:        "folder_owners_can_edit_documents",
+			Condition: `related(subject.id, "owner", object.folder_id)`,
+			Actions:   []string{"EDIT"},
+		},
+		{
+			ID:        "viewers_can_view",
+			Condition: `related(subject.id, "viewer", object.id)`,
+			Actions:   []string{"VIEW"},
+		},
+	}, nil, leges.WithRelationships(store, leges.RelationRewrite{
+		Relation:         "viewer",
+		ImpliedBy:        []string{"editor", "owner"},
+		InheritedThrough: []string{"parent"},
+	}))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		subjectID  string
+		action     string
+		object     leges.Attributes
+		expectedOk bool
+	}{
+		{"direct tuple", "user:1", "EDIT", leges.Attributes{"id": "doc:9", "folder_id": "folder:1"}, true},
+		{"missing tuple", "user:2", "EDIT", leges.Attributes{"id": "doc:9", "folder_id": "folder:1"}, false},
+		{"missing attribute", "user:1", "EDIT", leges.Attributes{"id": "doc:9"}, false},
+		{"userset", "user:2", "VIEW", leges.Attributes{"id": "folder:2"}, true},
+		{"nested userset", "user:3", "VIEW", leges.Attributes{"id": "folder:2"}, true},
+		{"inherited through parent", "user:3", "VIEW", leges.Attributes{"id": "doc:1"}, true},
+		{"implied by another relation", "user:4", "VIEW", leges.Attributes{"id": "doc:2"}, true},
+		{"implied through parent", "user:1", "VIEW", leges.Attributes{"id": "folder:1"}, true},
+		{"cycle", "user:1", "VIEW", leges.Attributes{"id": "folder:3"}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ok, _, err := rules.Match(leges.Request{
+				Action:  tc.action,
+				Subject: leges.Attributes{"id": tc.subjectID},
+				Object:  tc.object,
+			})
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOk, ok)
+		})
+	}
+
+	t.Run("deleted tuple", func(t *testing.T) {
+		store.Delete(tuples[0])
+		ok, _, err := rules.Match(leges.Request{
+			Action:  "EDIT",
+			Subject: leges.Attributes{"id": "user:1"},
+			Object:  leges.Attributes{"folder_id": "folder:1"},
+		})
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+}
+
+type failingTupleStore struct{}
+
+var errStoreUnavailable = errors.New("store unavailable")
+
+func (failingTupleStore) Subjects(ctx context.Context, object, relation string) ([]string, error) {
+	return nil, errStoreUnavailable
+}
+
+func TestRelationshipsError(t *testing.T) {
+	rules, err := leges.NewLeges([]leges.Policy{
+		{
+			ID:        "owners_can_edit",
+			Condition: `related(subject.id, "owner", object.id)`,
+			Actions:   []string{"EDIT"},
+		},
+	}, nil, leges.WithRelationships(failingTupleStore{}))
+	require.NoError(t, err)
+
+	_, _, err = rules.Match(leges.Request{
+		Action:  "EDIT",
+		Subject: leges.Attributes{"id": "user:1"},
+		Object:  leges.Attributes{"id": "doc:1"},
+	})
+	var runErr *leges.ErrExprRunFailed
+	require.True(t, errors.As(err, &runErr), "%v", err)
+	require.Contains(t, runErr.Err.Error(), errStoreUnavailable.Error())
+}
@@ -16,6 +16,10 @@ type Request struct {
 	Subject Attributes
 	// Object is the attributes of the object/resource.
 	Object Attributes
+	// Environment is the context of the request, such as the time or the
+	// IP address of the client. Conditions see it as env.*, along with the
+	// environment given to NewLeges, which it takes precedence over.
+	Environment Attributes
 }
 
 func (r Request) Validate() error {
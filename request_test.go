@@ -1,9 +1,11 @@
 package leges_test
 
 import (
+	"errors"
+	"testing"
+
 	"github.com/siadat/leges"
 	"github.com/stretchr/testify/require"
-	"testing"
 )
 
 func TestRequest_Validate(t *testing.T) {
@@ -72,3 +74,62 @@ func TestRequest_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestRequestEnvironment(t *testing.T) {
+	rules, err := leges.NewLeges([]leges.Policy{
+		{
+			ID:        "adults_can_view",
+			Condition: `subject.age >= env.min_age and env.mfa == true`,
+			Actions:   []string{"VIEW"},
+		},
+	}, leges.Attributes{"min_age": 18, "mfa": false})
+	require.NoError(t, err)
+
+	request := leges.Request{
+		Action:  "VIEW",
+		Subject: leges.Attributes{"age": 16},
+		Object:  leges.Attributes{"type": "page"},
+	}
+
+	tests := []struct {
+		name        string
+		environment leges.Attributes
+		match       bool
+	}{
+		{
+			name:        "the static environment is used without a request environment",
+			environment: nil,
+			match:       false,
+		},
+		{
+			name:        "the request environment is merged with the static environment",
+			environment: leges.Attributes{"mfa": true},
+			match:       false,
+		},
+		{
+			name:        "the request environment takes precedence",
+			environment: leges.Attributes{"mfa": true, "min_age": 16},
+			match:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request.Environment = test.environment
+			ok, _, err := rules.Match(request)
+			require.NoError(t, err)
+			require.Equal(t, test.match, ok)
+
+			results := rules.MatchBatch([]leges.Request{request})
+			require.NoError(t, results[0].Err)
+			require.Equal(t, test.match, results[0].Match)
+		})
+	}
+
+	t.Run("env is a reserved function name", func(t *testing.T) {
+		_, err := leges.NewLeges(nil, nil, leges.WithFunctions(map[string]interface{}{
+			"env": func() bool { return true },
+		}))
+		require.True(t, errors.Is(err, leges.ErrInvalidFunction))
+	})
+}
@@ -0,0 +1,189 @@
+package leges
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Residual is what remains of conditions after partial evaluation: a
+// predicate over the attributes that were unknown. It is one of Constant,
+// And, Or, Not and Comparison.
+type Residual interface {
+	// String formats the residual as an expression
+	String() string
+	isResidual()
+}
+
+// Constant is a residual that does not depend on the unknown attributes
+type Constant bool
+
+// And holds if all of its residuals hold, an empty And always holds
+type And []Residual
+
+// Or holds if any of its residuals holds, an empty Or never holds
+type Or []Residual
+
+// Not holds if its residual doesn't
+type Not struct {
+	Residual Residual
+}
+
+// Comparison compares an unknown attribute with a known value.
+type Comparison struct {
+	// Field is the path of the attribute, including the root, for example
+	// []string{"object", "owner_id"}
+	Field []string
+	// Operator is one of "==", "!=", "<", "<=", ">", ">=", "in" (Value is a
+	// []interface{} holding the attribute) and "contains" (the attribute is
+	// an array holding Value)
+	Operator string
+	Value    interface{}
+}
+
+func (Constant) isResidual()   {}
+func (And) isResidual()        {}
+func (Or) isResidual()         {}
+func (Not) isResidual()        {}
+func (Comparison) isResidual() {}
+
+func (c Constant) String() string {
+	return strconv.FormatBool(bool(c))
+}
+
+func (a And) String() string {
+	if len(a) == 0 {
+		return "true"
+	}
+	return joinResiduals(a, " and ")
+}
+
+func (o Or) String() string {
+	if len(o) == 0 {
+		return "false"
+	}
+	return joinResiduals(o, " or ")
+}
+
+func (n Not) String() string {
+	// not binds tighter than comparisons, not x == 1 is (not x) == 1
+	if _, ok := n.Residual.(Comparison); ok {
+		return "not (" + n.Residual.String() + ")"
+	}
+	return "not " + formatResidualOperand(n.Residual)
+}
+
+func (c Comparison) String() string {
+	field := strings.Join(c.Field, ".")
+	if c.Operator == "contains" {
+		return formatValue(c.Value) + " in " + field
+	}
+	return field + " " + c.Operator + " " + formatValue(c.Value)
+}
+
+func joinResiduals(residuals []Residual, separator string) string {
+	formatted := make([]string, len(residuals))
+	for i, residual := range residuals {
+		formatted[i] = formatResidualOperand(residual)
+	}
+	return strings.Join(formatted, separator)
+}
+
+func formatResidualOperand(residual Residual) string {
+	switch r := residual.(type) {
+	case And:
+		if len(r) > 1 {
+			return "(" + r.String() + ")"
+		}
+	case Or:
+		if len(r) > 1 {
+			return "(" + r.String() + ")"
+		}
+	}
+	return residual.String()
+}
+
+// formatValue formats a value as an expression literal
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return strconv.Quote(v)
+	case []interface{}:
+		formatted := make([]string, len(v))
+		for i, element := range v {
+			formatted[i] = formatValue(element)
+		}
+		return "[" + strings.Join(formatted, ", ") + "]"
+	case []string:
+		formatted := make([]string, len(v))
+		for i, element := range v {
+			formatted[i] = strconv.Quote(element)
+		}
+		return "[" + strings.Join(formatted, ", ") + "]"
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// and returns the conjunction of two residuals, simplifying constants and
+// flattening nested conjunctions
+func and(a, b Residual) Residual {
+	var operands And
+	for _, r := range []Residual{a, b} {
+		switch r := r.(type) {
+		case Constant:
+			if !r {
+				return Constant(false)
+			}
+		case And:
+			operands = append(operands, r...)
+		default:
+			operands = append(operands, r)
+		}
+	}
+	if len(operands) == 0 {
+		return Constant(true)
+	}
+	if len(operands) == 1 {
+		return operands[0]
+	}
+	return operands
+}
+
+// or returns the disjunction of two residuals, simplifying constants and
+// flattening nested disjunctions
+func or(a, b Residual) Residual {
+	var operands Or
+	for _, r := range []Residual{a, b} {
+		switch r := r.(type) {
+		case Constant:
+			if r {
+				return Constant(true)
+			}
+		case Or:
+			operands = append(operands, r...)
+		default:
+			operands = append(operands, r)
+		}
+	}
+	if len(operands) == 0 {
+		return Constant(false)
+	}
+	if len(operands) == 1 {
+		return operands[0]
+	}
+	return operands
+}
+
+// not returns the negation of a residual, simplifying constants and double
+// negations
+func not(r Residual) Residual {
+	switch r := r.(type) {
+	case Constant:
+		return !r
+	case Not:
+		return r.Residual
+	}
+	return Not{Residual: r}
+}
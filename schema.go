@@ -0,0 +1,401 @@
+package leges
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/antonmedv/expr/ast"
+	"github.com/antonmedv/expr/parser"
+)
+
+var ErrInvalidSchema = errors.New("invalid schema")
+
+// Schema declares the attributes conditions may refer to. When a Leges has a
+// schema, conditions referring to undeclared attributes, comparing attributes
+// with values of another type, or passing them to functions of WithFunctions
+// whose parameters have another type, fail to load.
+type Schema struct {
+	// Subject describes the subject attributes, i.e. subject.*
+	Subject *AttributeSchema `json:"subject" yaml:"subject"`
+	// Object describes the object attributes, i.e. object.*
+	Object *AttributeSchema `json:"object" yaml:"object"`
+	// Environment describes the environment attributes given to NewLeges,
+	// and the attributes of env.*, which may also come from the request
+	Environment *AttributeSchema `json:"environment" yaml:"environment"`
+}
+
+// AttributeSchema describes an attribute using a subset of JSON Schema. An
+// empty Type accepts any value. An object without Properties accepts any
+// field.
+type AttributeSchema struct {
+	// Type is one of "string", "number", "integer", "boolean", "array",
+	// "object" and "null"
+	Type string `json:"type,omitempty" yaml:"type"`
+	// Properties are the fields of an object
+	Properties map[string]*AttributeSchema `json:"properties,omitempty" yaml:"properties"`
+	// Items describes the elements of an array
+	Items *AttributeSchema `json:"items,omitempty" yaml:"items"`
+}
+
+// ErrSchemaMismatch is returned when a condition does not conform to the
+// schema. Line is 1-based and Column is 0-based.
+type ErrSchemaMismatch struct {
+	PolicyID string
+	Line     int
+	Column   int
+	Message  string
+}
+
+func (e *ErrSchemaMismatch) Error() string {
+	return fmt.Sprintf("policy %q does not match the schema at line %d, column %d: %s", e.PolicyID, e.Line, e.Column, e.Message)
+}
+
+// WithSchema type-checks the conditions of the policies against schema when
+// they are loaded.
+func WithSchema(schema *Schema) Option {
+	return func(l *Leges) error {
+		for name, s := range map[string]*AttributeSchema{
+			"subject":     schema.Subject,
+			"object":      schema.Object,
+			"environment": schema.Environment,
+		} {
+			if err := s.validate(name); err != nil {
+				return err
+			}
+		}
+		l.schema = schema
+		return nil
+	}
+}
+
+func (s *AttributeSchema) validate(path string) error {
+	if s == nil {
+		return nil
+	}
+
+	switch s.Type {
+	case "", "string", "number", "integer", "boolean", "array", "object", "null":
+	default:
+		return fmt.Errorf("%s has type %q: %w", path, s.Type, ErrInvalidSchema)
+	}
+
+	for name, property := range s.Properties {
+		if err := property.validate(path + "." + name); err != nil {
+			return err
+		}
+	}
+
+	return s.Items.validate(path + "[]")
+}
+
+// check type-checks the parsed condition of a policy. The arguments of calls
+// to functions are checked against their parameters.
+func (s *Schema) check(policy Policy, functions map[string]interface{}) error {
+	tree, err := parser.Parse(policy.Condition)
+	if err != nil {
+		return err
+	}
+
+	c := &schemaChecker{schema: s, functions: functions}
+	t := c.typeOf(tree.Node)
+	if c.err != nil {
+		c.err.PolicyID = policy.ID
+		return c.err
+	}
+	if t != "" && t != "boolean" {
+		return &ErrNonBooleanCondition{
+			PolicyID: policy.ID,
+			Type:     t,
+		}
+	}
+
+	return nil
+}
+
+// schemaChecker infers the types of the nodes of a condition. Type "" means
+// the type is unknown, and is compatible with every other type.
+type schemaChecker struct {
+	schema    *Schema
+	functions map[string]interface{}
+	err       *ErrSchemaMismatch
+}
+
+func (c *schemaChecker) fail(node ast.Node, format string, args ...interface{}) {
+	if c.err != nil {
+		return
+	}
+	c.err = &ErrSchemaMismatch{
+		Line:    node.Location().Line,
+		Column:  node.Location().Column,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (c *schemaChecker) typeOf(node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.NilNode:
+		return "null"
+	case *ast.StringNode:
+		return "string"
+	case *ast.IntegerNode:
+		return "integer"
+	case *ast.FloatNode:
+		return "number"
+	case *ast.BoolNode:
+		return "boolean"
+	case *ast.ArrayNode:
+		for _, element := range n.Nodes {
+			c.typeOf(element)
+		}
+		return "array"
+	case *ast.MapNode:
+		for _, pair := range n.Pairs {
+			c.typeOf(pair)
+		}
+		return "object"
+	case *ast.IdentifierNode, *ast.PropertyNode, *ast.IndexNode:
+		if s := c.resolve(node); s != nil {
+			return s.Type
+		}
+		return ""
+	case *ast.UnaryNode:
+		t := c.typeOf(n.Node)
+		switch n.Operator {
+		case "not", "!":
+			c.expect(n.Node, t, "boolean")
+			return "boolean"
+		default:
+			c.expect(n.Node, t, "number")
+			return t
+		}
+	case *ast.BinaryNode:
+		return c.binaryType(n)
+	case *ast.MatchesNode:
+		c.expect(n.Left, c.typeOf(n.Left), "string")
+		c.expect(n.Right, c.typeOf(n.Right), "string")
+		return "boolean"
+	case *ast.ConditionalNode:
+		c.expect(n.Cond, c.typeOf(n.Cond), "boolean")
+		then, otherwise := c.typeOf(n.Exp1), c.typeOf(n.Exp2)
+		if then == otherwise {
+			return then
+		}
+		return ""
+	case *ast.FunctionNode:
+		if fn, ok := c.functions[n.Name]; ok {
+			return c.callType(n, reflect.TypeOf(fn))
+		}
+	}
+
+	for _, child := range childNodes(node) {
+		c.typeOf(child)
+	}
+	return ""
+}
+
+// callType checks the arguments of a call to a function of type fn, and
+// returns the type of its result
+func (c *schemaChecker) callType(n *ast.FunctionNode, fn reflect.Type) string {
+	for i, argument := range n.Arguments {
+		var param reflect.Type
+		switch {
+		case fn.IsVariadic() && i >= fn.NumIn()-1:
+			param = fn.In(fn.NumIn() - 1).Elem()
+		case i < fn.NumIn():
+			param = fn.In(i)
+		}
+
+		t := c.typeOf(argument)
+		if expected := schemaType(param); expected != "" {
+			c.expect(argument, t, expected)
+		}
+	}
+	return schemaType(fn.Out(0))
+}
+
+// schemaType returns the schema type of the values of a Go type, or "" if it
+// is not known
+func schemaType(t reflect.Type) string {
+	if t == nil {
+		return ""
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map:
+		return "object"
+	}
+	return ""
+}
+
+func (c *schemaChecker) binaryType(n *ast.BinaryNode) string {
+	left, right := c.typeOf(n.Left), c.typeOf(n.Right)
+
+	switch n.Operator {
+	case "and", "&&", "or", "||":
+		c.expect(n.Left, left, "boolean")
+		c.expect(n.Right, right, "boolean")
+		return "boolean"
+	case "==", "!=":
+		if !typesComparable(left, right) {
+			c.fail(n, "cannot compare %s %s %s (%s and %s)", formatNode(n.Left), n.Operator, formatNode(n.Right), left, right)
+		}
+		return "boolean"
+	case "<", ">", "<=", ">=":
+		if !(isNumeric(left) && isNumeric(right)) && !(left == "string" && right == "string") && left != "" && right != "" {
+			c.fail(n, "cannot compare %s %s %s (%s and %s)", formatNode(n.Left), n.Operator, formatNode(n.Right), left, right)
+		}
+		return "boolean"
+	case "in", "not in":
+		switch right {
+		case "", "object":
+		case "array":
+			if elements := c.elementTypes(n.Right); len(elements) > 0 {
+				ok := false
+				for _, element := range elements {
+					ok = ok || typesComparable(left, element)
+				}
+				if !ok {
+					c.fail(n, "%s is %s, but %s contains %s", formatNode(n.Left), left, formatNode(n.Right), strings.Join(elements, ", "))
+				}
+			}
+		default:
+			c.fail(n.Right, "%s is %s, expected array or object", formatNode(n.Right), right)
+		}
+		return "boolean"
+	case "contains", "startsWith", "endsWith":
+		c.expect(n.Left, left, "string")
+		c.expect(n.Right, right, "string")
+		return "boolean"
+	case "..":
+		c.expect(n.Left, left, "integer")
+		c.expect(n.Right, right, "integer")
+		return "array"
+	case "+":
+		if left == "string" || right == "string" {
+			c.expect(n.Left, left, "string")
+			c.expect(n.Right, right, "string")
+			return "string"
+		}
+		fallthrough
+	default:
+		c.expect(n.Left, left, "number")
+		c.expect(n.Right, right, "number")
+		if left == "integer" && right == "integer" && n.Operator != "/" && n.Operator != "**" {
+			return "integer"
+		}
+		return "number"
+	}
+}
+
+// elementTypes returns the known types of the elements of an array node
+func (c *schemaChecker) elementTypes(node ast.Node) []string {
+	if array, ok := node.(*ast.ArrayNode); ok {
+		types := map[string]bool{}
+		for _, element := range array.Nodes {
+			t := c.typeOf(element)
+			if t == "" {
+				return nil
+			}
+			types[t] = true
+		}
+		var elements []string
+		for t := range types {
+			elements = append(elements, t)
+		}
+		sort.Strings(elements)
+		return elements
+	}
+
+	if s := c.resolve(node); s != nil && s.Items != nil && s.Items.Type != "" {
+		return []string{s.Items.Type}
+	}
+	return nil
+}
+
+func (c *schemaChecker) expect(node ast.Node, actual, expected string) {
+	if actual == "" || actual == expected || (expected == "number" && actual == "integer") {
+		return
+	}
+	c.fail(node, "%s is %s, expected %s", formatNode(node), actual, expected)
+}
+
+// resolve returns the schema of an attribute reference, or nil if it is not
+// known
+func (c *schemaChecker) resolve(node ast.Node) *AttributeSchema {
+	switch n := node.(type) {
+	case *ast.IdentifierNode:
+		switch n.Value {
+		case "subject":
+			return c.schema.Subject
+		case "object":
+			return c.schema.Object
+		case "env":
+			return c.schema.Environment
+		case "debug":
+			return nil
+		}
+		return c.field(n, c.schema.Environment, "environment", n.Value)
+	case *ast.PropertyNode:
+		return c.field(n, c.resolve(n.Node), formatNode(n.Node), n.Property)
+	case *ast.IndexNode:
+		base := c.resolve(n.Node)
+		index := c.typeOf(n.Index)
+		if base == nil {
+			return nil
+		}
+		switch base.Type {
+		case "array":
+			c.expect(n.Index, index, "integer")
+			return base.Items
+		case "object", "":
+			if key, ok := n.Index.(*ast.StringNode); ok {
+				return c.field(n, base, formatNode(n.Node), key.Value)
+			}
+			return nil
+		}
+		c.fail(n, "%s is %s, it can't be indexed", formatNode(n.Node), base.Type)
+	}
+	return nil
+}
+
+// field returns the schema of a field of an object schema
+func (c *schemaChecker) field(node ast.Node, s *AttributeSchema, name, field string) *AttributeSchema {
+	if s == nil {
+		return nil
+	}
+	if s.Type != "" && s.Type != "object" {
+		c.fail(node, "%s is %s, it has no field %q", name, s.Type, field)
+		return nil
+	}
+	if s.Properties == nil {
+		return nil
+	}
+
+	property, ok := s.Properties[field]
+	if !ok {
+		c.fail(node, "unknown field %q in %s", field, name)
+		return nil
+	}
+	return property
+}
+
+func typesComparable(a, b string) bool {
+	return a == "" || b == "" || a == b || a == "null" || b == "null" || (isNumeric(a) && isNumeric(b))
+}
+
+func isNumeric(t string) bool {
+	return t == "number" || t == "integer"
+}
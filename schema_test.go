@@ -0,0 +1,158 @@
+package leges_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchema(t *testing.T) {
+	var schema leges.Schema
+	err := json.Unmarshal([]byte(`{
+		"subject": {
+			"type": "object",
+			"properties": {
+				"id": {"type": "string"},
+				"role": {"type": "string"},
+				"age": {"type": "integer"},
+				"groups": {"type": "array", "items": {"type": "string"}}
+			}
+		},
+		"object": {
+			"type": "object",
+			"properties": {
+				"type": {"type": "string"},
+				"owner_id": {"type": "string"},
+				"locked": {"type": "boolean"},
+				"meta": {"type": "object"}
+			}
+		},
+		"environment": {
+			"type": "object",
+			"properties": {
+				"min_age": {"type": "integer"}
+			}
+		}
+	}`), &schema)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		condition       string
+		expectedMessage string
+	}{
+		{
+			condition: `subject.role == "admin" and object.type in ["page", "adminpage"]`,
+		},
+		{
+			condition: `subject.age >= min_age and not object.locked and "staff" in subject.groups`,
+		},
+		{
+			condition: `object.meta.anything == subject.id and debug(subject)`,
+		},
+		{
+			condition:       `subject.rol == "admin"`,
+			expectedMessage: `unknown field "rol" in subject`,
+		},
+		{
+			condition:       `subject.role == true`,
+			expectedMessage: `cannot compare subject.role == true (string and boolean)`,
+		},
+		{
+			condition:       `subject.age > "18"`,
+			expectedMessage: `cannot compare subject.age > "18" (integer and string)`,
+		},
+		{
+			condition:       `object.locked and object.type`,
+			expectedMessage: `object.type is string, expected boolean`,
+		},
+		{
+			condition:       `subject.age in ["1", "2"]`,
+			expectedMessage: `subject.age is integer, but ["1", "2"] contains string`,
+		},
+		{
+			condition:       `subject.role.name == "x"`,
+			expectedMessage: `subject.role is string, it has no field "name"`,
+		},
+		{
+			condition:       `max_age > 10`,
+			expectedMessage: `unknown field "max_age" in environment`,
+		},
+		{
+			condition: `subject.age >= env.min_age`,
+		},
+		{
+			condition:       `env.max_age > 10`,
+			expectedMessage: `unknown field "max_age" in env`,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.condition, func(t *testing.T) {
+			_, err := leges.NewLeges([]leges.Policy{
+				{
+					ID:        "policy1",
+					Condition: tt.condition,
+					Actions:   []string{"ACTION"},
+				},
+			}, leges.Attributes{"min_age": 18}, leges.WithSchema(&schema))
+
+			if tt.expectedMessage == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			var schemaErr *leges.ErrSchemaMismatch
+			require.True(t, errors.As(err, &schemaErr), "error: %v", err)
+			require.Equal(t, "policy1", schemaErr.PolicyID)
+			require.Equal(t, 1, schemaErr.Line)
+			require.Equal(t, tt.expectedMessage, schemaErr.Message)
+		})
+	}
+
+	t.Run("arguments of functions are checked", func(t *testing.T) {
+		schema := &leges.Schema{
+			Subject: &leges.AttributeSchema{
+				Type: "object",
+				Properties: map[string]*leges.AttributeSchema{
+					"ip":   {Type: "integer"},
+					"name": {Type: "string"},
+				},
+			},
+		}
+
+		for condition, expectedMessage := range map[string]string{
+			`cidrContains("10.0.0.0/8", subject.ip)`: `subject.ip is integer, expected string`,
+			`regexMatch("^10[.]", subject.ip)`:       `subject.ip is integer, expected string`,
+			`lower(subject.name) > subject.ip`:       `cannot compare lower(subject.name) > subject.ip (string and integer)`,
+			`lower(subject.name) == "alice"`:         ``,
+		} {
+			_, err := leges.NewLeges([]leges.Policy{
+				{ID: "policy1", Condition: condition, Actions: []string{"ACTION"}},
+			}, nil, leges.WithSchema(schema), leges.WithFunctions(leges.StandardLibrary()))
+
+			if expectedMessage == "" {
+				require.NoError(t, err, condition)
+				continue
+			}
+
+			var schemaErr *leges.ErrSchemaMismatch
+			require.True(t, errors.As(err, &schemaErr), "condition %s: %v", condition, err)
+			require.Equal(t, expectedMessage, schemaErr.Message)
+		}
+	})
+
+	t.Run("err if schema has an unknown type", func(t *testing.T) {
+		_, err := leges.NewLeges(nil, nil, leges.WithSchema(&leges.Schema{
+			Subject: &leges.AttributeSchema{
+				Type: "object",
+				Properties: map[string]*leges.AttributeSchema{
+					"id": {Type: "uuid"},
+				},
+			},
+		}))
+		require.True(t, errors.Is(err, leges.ErrInvalidSchema))
+	})
+}
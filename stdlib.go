@@ -0,0 +1,366 @@
+package leges
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StandardLibrary returns a set of common functions for WithFunctions:
+//
+//	cidrContains(cidr, ip string) bool      ip is in the network cidr
+//	isPrivateIP(ip string) bool             ip is a private, loopback or link-local address
+//	timeBetween(t, from, to string) bool    the clock time of t is in [from, to), such as "09:00" and "17:00", wrapping around midnight if from > to
+//	weekday(t) string                       the day of the week of t, such as "Monday"
+//	isBusinessHours(t) bool                 t is between 09:00 and 17:00 from Monday to Friday
+//	timeBefore(a, b) bool                   a is before b
+//	timeAfter(a, b) bool                    a is after b
+//	regexMatch(pattern, s string) bool      s matches the regular expression pattern
+//	globMatch(pattern, s string) bool       s matches pattern, in which "*" matches any sequence of characters
+//	lower(s string) string                  s in lower case
+//	upper(s string) string                  s in upper case
+//	trim(s string) string                   s without leading and trailing white space
+//	intersects(a, b) bool                   the lists a and b have a common element
+//	subsetOf(a, b) bool                     every element of the list a is in the list b
+//	intersection(a, b) []interface{}        the elements of a that are in b
+//	union(a, b) []interface{}               the elements of a and the elements of b that are not in a
+//	difference(a, b) []interface{}          the elements of a that are not in b
+//	semverCompare(a, b string) int          -1, 0 or 1 if the semantic version a is lower than, equal to or greater than b
+//	semverGte(a, b string) bool             the semantic version a is greater than or equal to b
+//
+// Times are time.Time values or RFC 3339 strings. Invalid arguments, such as
+// a malformed IP address or version, make the condition fail to evaluate.
+func StandardLibrary() map[string]interface{} {
+	return map[string]interface{}{
+		"cidrContains":    cidrContains,
+		"isPrivateIP":     isPrivateIP,
+		"timeBetween":     timeBetween,
+		"weekday":         weekday,
+		"isBusinessHours": isBusinessHours,
+		"timeBefore":      timeBefore,
+		"timeAfter":       timeAfter,
+		"regexMatch":      regexMatch,
+		"globMatch":       matchAction,
+		"lower":           strings.ToLower,
+		"upper":           strings.ToUpper,
+		"trim":            strings.TrimSpace,
+		"intersects":      intersects,
+		"subsetOf":        subsetOf,
+		"intersection":    intersection,
+		"union":           union,
+		"difference":      difference,
+		"semverCompare":   semverCompare,
+		"semverGte":       semverGte,
+	}
+}
+
+func parseIP(s string) net.IP {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		panic(fmt.Errorf("invalid IP address %q", s))
+	}
+	return ip
+}
+
+func cidrContains(cidr, ip string) bool {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network.Contains(parseIP(ip))
+}
+
+var privateNetworks = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"fc00::/7",
+	"fe80::/10",
+	"::1/128",
+}
+
+func isPrivateIP(ip string) bool {
+	parsed := parseIP(ip)
+	for _, cidr := range privateNetworks {
+		_, network, _ := net.ParseCIDR(cidr)
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// toTime converts a time.Time or an RFC 3339 string to a time.Time
+func toTime(value interface{}) time.Time {
+	switch v := value.(type) {
+	case time.Time:
+		return v
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	panic(fmt.Errorf("invalid time %v of type %T", value, value))
+}
+
+// clock parses a clock time such as "09:30" to minutes since midnight
+func clock(s string) int {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t.Hour()*60 + t.Minute()
+}
+
+func timeBetween(t interface{}, from, to string) bool {
+	tt := toTime(t)
+	minutes := tt.Hour()*60 + tt.Minute()
+	start, end := clock(from), clock(to)
+	if start <= end {
+		return start <= minutes && minutes < end
+	}
+	return minutes >= start || minutes < end
+}
+
+func weekday(t interface{}) string {
+	return toTime(t).Weekday().String()
+}
+
+func isBusinessHours(t interface{}) bool {
+	switch toTime(t).Weekday() {
+	case time.Saturday, time.Sunday:
+		return false
+	}
+	return timeBetween(t, "09:00", "17:00")
+}
+
+func timeBefore(a, b interface{}) bool {
+	return toTime(a).Before(toTime(b))
+}
+
+func timeAfter(a, b interface{}) bool {
+	return toTime(a).After(toTime(b))
+}
+
+// maxRegexps is the number of compiled patterns regexMatch keeps
+const maxRegexps = 256
+
+// regexps caches the patterns of regexMatch, which are usually literals of
+// the policies, but may come from the request as well
+var regexps = &regexpCache{
+	entries: map[string]*list.Element{},
+	order:   list.New(),
+}
+
+// regexpCache is a cache of compiled patterns, which evicts the least recently
+// used pattern once it has maxRegexps patterns
+type regexpCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type regexpEntry struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func (c *regexpCache) get(pattern string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	if element, ok := c.entries[pattern]; ok {
+		c.order.MoveToFront(element)
+		c.mu.Unlock()
+		return element.Value.(*regexpEntry).re, nil
+	}
+	c.mu.Unlock()
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[pattern]; ok {
+		c.order.MoveToFront(element)
+		return element.Value.(*regexpEntry).re, nil
+	}
+	c.entries[pattern] = c.order.PushFront(&regexpEntry{pattern: pattern, re: re})
+	if c.order.Len() > maxRegexps {
+		oldest := c.order.Remove(c.order.Back()).(*regexpEntry)
+		delete(c.entries, oldest.pattern)
+	}
+	return re, nil
+}
+
+func regexMatch(pattern, s string) bool {
+	re, err := regexps.get(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return re.MatchString(s)
+}
+
+// toList converts a slice or an array of any type to []interface{}
+func toList(value interface{}) []interface{} {
+	if list, ok := value.([]interface{}); ok {
+		return list
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = v.Index(i).Interface()
+		}
+		return list
+	case reflect.Invalid:
+		return nil
+	}
+	panic(fmt.Errorf("invalid list %v of type %T", value, value))
+}
+
+func listIncludes(list []interface{}, needle interface{}) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(item, needle) {
+			return true
+		}
+	}
+	return false
+}
+
+func intersects(a, b interface{}) bool {
+	return len(intersection(a, b)) > 0
+}
+
+func subsetOf(a, b interface{}) bool {
+	return len(difference(a, b)) == 0
+}
+
+func intersection(a, b interface{}) []interface{} {
+	result := []interface{}{}
+	other := toList(b)
+	for _, item := range toList(a) {
+		if listIncludes(other, item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func union(a, b interface{}) []interface{} {
+	result := append([]interface{}{}, toList(a)...)
+	for _, item := range toList(b) {
+		if !listIncludes(result, item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func difference(a, b interface{}) []interface{} {
+	result := []interface{}{}
+	other := toList(b)
+	for _, item := range toList(a) {
+		if !listIncludes(other, item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// semver is a parsed semantic version, build metadata is ignored
+type semver struct {
+	numbers    [3]int
+	prerelease []string
+}
+
+func parseSemver(s string) semver {
+	var v semver
+	version := strings.TrimPrefix(s, "v")
+	if i := strings.Index(version, "+"); i >= 0 {
+		version = version[:i]
+	}
+	if i := strings.Index(version, "-"); i >= 0 {
+		v.prerelease = strings.Split(version[i+1:], ".")
+		version = version[:i]
+	}
+
+	parts := strings.Split(version, ".")
+	if len(parts) > 3 {
+		panic(fmt.Errorf("invalid version %q", s))
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			panic(fmt.Errorf("invalid version %q", s))
+		}
+		v.numbers[i] = n
+	}
+	return v
+}
+
+func semverCompare(a, b string) int {
+	va, vb := parseSemver(a), parseSemver(b)
+	for i := range va.numbers {
+		if c := compareInts(va.numbers[i], vb.numbers[i]); c != 0 {
+			return c
+		}
+	}
+
+	// a version without a pre-release is greater than one with it
+	switch {
+	case len(va.prerelease) == 0 && len(vb.prerelease) == 0:
+		return 0
+	case len(va.prerelease) == 0:
+		return 1
+	case len(vb.prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(va.prerelease) && i < len(vb.prerelease); i++ {
+		x, y := va.prerelease[i], vb.prerelease[i]
+		nx, errX := strconv.Atoi(x)
+		ny, errY := strconv.Atoi(y)
+		var c int
+		switch {
+		case errX == nil && errY == nil:
+			c = compareInts(nx, ny)
+		case errX == nil:
+			c = -1
+		case errY == nil:
+			c = 1
+		default:
+			c = strings.Compare(x, y)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(va.prerelease), len(vb.prerelease))
+}
+
+func semverGte(a, b string) bool {
+	return semverCompare(a, b) >= 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
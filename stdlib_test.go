@@ -0,0 +1,122 @@
+package leges_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStandardLibrary(t *testing.T) {
+	subject := leges.Attributes{
+		"ip":      "10.1.2.3",
+		"groups":  []interface{}{"eng", "ops"},
+		"name":    " Alice ",
+		"version": "v1.10.0-rc.1",
+		// a Wednesday
+		"time": time.Date(2020, 7, 15, 18, 30, 0, 0, time.UTC),
+	}
+
+	testCases := []struct {
+		condition string
+		expected  bool
+	}{
+		{`cidrContains("10.0.0.0/8", subject.ip)`, true},
+		{`cidrContains("192.168.0.0/16", subject.ip)`, false},
+		{`cidrContains("2001:db8::/32", "2001:db8::1")`, true},
+		{`isPrivateIP(subject.ip)`, true},
+		{`isPrivateIP("8.8.8.8")`, false},
+		{`timeBetween(subject.time, "09:00", "17:00")`, false},
+		{`timeBetween(subject.time, "18:00", "02:00")`, true},
+		{`timeBetween("2020-07-15T01:00:00Z", "18:00", "02:00")`, true},
+		{`weekday(subject.time) == "Wednesday"`, true},
+		{`isBusinessHours(subject.time)`, false},
+		{`isBusinessHours("2020-07-15T10:00:00Z")`, true},
+		{`isBusinessHours("2020-07-18T10:00:00Z")`, false},
+		{`timeBefore(subject.time, "2021-01-01T00:00:00Z")`, true},
+		{`timeAfter(subject.time, "2021-01-01T00:00:00Z")`, false},
+		{`regexMatch("^A", trim(subject.name))`, true},
+		{`globMatch("*.example.com", "api.example.com")`, true},
+		{`globMatch("*.example.com", "example.com")`, false},
+		{`lower(trim(subject.name)) == "alice"`, true},
+		{`upper("a") == "A"`, true},
+		{`intersects(subject.groups, ["ops", "sales"])`, true},
+		{`intersects(subject.groups, ["sales"])`, false},
+		{`subsetOf(subject.groups, ["eng", "ops", "sales"])`, true},
+		{`subsetOf(["eng", "hr"], subject.groups)`, false},
+		{`"ops" in intersection(subject.groups, ["ops", "sales"])`, true},
+		{`len(intersection(subject.groups, ["ops", "sales"])) == 1`, true},
+		{`len(union(subject.groups, ["ops", "sales"])) == 3`, true},
+		{`difference(subject.groups, ["ops"])[0] == "eng"`, true},
+		{`semverGte(subject.version, "1.9.3")`, true},
+		{`semverGte(subject.version, "1.10.0")`, false},
+		{`semverCompare("1.0.0-alpha", "1.0.0-alpha.1") == -1`, true},
+		{`semverCompare("1.0.0-alpha.beta", "1.0.0-beta") == -1`, true},
+		{`semverCompare("1.0.0-rc.11", "1.0.0-rc.2") == 1`, true},
+		{`semverCompare("2.0", "v2.0.0+build.5") == 0`, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.condition, func(t *testing.T) {
+			rules, err := leges.NewLeges([]leges.Policy{
+				{ID: "policy1", Condition: tc.condition, Actions: []string{"VIEW"}},
+			}, nil, leges.WithFunctions(leges.StandardLibrary()))
+			require.NoError(t, err)
+
+			ok, _, err := rules.Match(leges.Request{
+				Action:  "VIEW",
+				Subject: subject,
+				Object:  leges.Attributes{"type": "page"},
+			})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, ok)
+		})
+	}
+}
+
+func TestStandardLibraryErrors(t *testing.T) {
+	for _, condition := range []string{
+		`cidrContains("10.0.0.0/8", "not an ip")`,
+		`cidrContains("10.0.0.0", "10.0.0.1")`,
+		`timeBetween("yesterday", "09:00", "17:00")`,
+		`timeBetween(subject.time, "9am", "5pm")`,
+		`regexMatch("(", "a")`,
+		`intersects(1, [1])`,
+		`semverGte("1.x", "1.0.0")`,
+	} {
+		t.Run(condition, func(t *testing.T) {
+			rules, err := leges.NewLeges([]leges.Policy{
+				{ID: "policy1", Condition: condition, Actions: []string{"VIEW"}},
+			}, nil, leges.WithFunctions(leges.StandardLibrary()))
+			require.NoError(t, err)
+
+			_, _, err = rules.Match(leges.Request{
+				Action:  "VIEW",
+				Subject: leges.Attributes{"time": "2020-07-15T10:00:00Z"},
+				Object:  leges.Attributes{"type": "page"},
+			})
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestStandardLibraryRegexMatchManyPatterns(t *testing.T) {
+	rules, err := leges.NewLeges([]leges.Policy{
+		{ID: "policy1", Condition: `regexMatch(subject.pattern, object.name)`, Actions: []string{"VIEW"}},
+	}, nil, leges.WithFunctions(leges.StandardLibrary()))
+	require.NoError(t, err)
+
+	// more patterns than are cached, and then the first ones again
+	for i := 0; i < 600; i++ {
+		n := i % 400
+		ok, _, err := rules.Match(leges.Request{
+			Action:  "VIEW",
+			Subject: leges.Attributes{"pattern": fmt.Sprintf("^file%d$", n)},
+			Object:  leges.Attributes{"name": fmt.Sprintf("file%d", n)},
+		})
+		require.NoError(t, err)
+		require.True(t, ok, "pattern %d", n)
+	}
+}
@@ -0,0 +1,96 @@
+package leges
+
+import "context"
+
+// SubjectConstraint is what a policy requires of the subject for a given
+// object and action.
+type SubjectConstraint struct {
+	Policy Policy
+	// Residual is a predicate over subject.*, for example
+	// subject.role == "admin"
+	Residual Residual
+}
+
+// SubjectConstraints answers "who can perform action on object?". It
+// partially evaluates the policies of action with the subject unknown, and
+// returns the constraint each of them puts on the subject, in evaluation
+// order. Policies that can't apply to object are left out, policies of both
+// effects are included.
+func (l *Leges) SubjectConstraints(object Attributes, action string) ([]SubjectConstraint, error) {
+	return l.SubjectConstraintsContext(context.Background(), Request{Object: object, Action: action})
+}
+
+// SubjectConstraintsContext is like SubjectConstraints, but takes the object,
+// the action and the environment from request, whose Subject is ignored, and
+// evaluates the policies with ctx.
+func (l *Leges) SubjectConstraintsContext(ctx context.Context, request Request) ([]SubjectConstraint, error) {
+	if len(request.Object) == 0 {
+		return nil, ErrEmptyObjectAttrs
+	}
+	if request.Action == "" {
+		return nil, ErrEmptyAction
+	}
+
+	object, err := l.resolveAll(ctx, "object", request.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	env := l.normalizeRequest(ctx, Request{
+		Action:      request.Action,
+		Object:      object,
+		Environment: request.Environment,
+	})
+
+	var constraints []SubjectConstraint
+	requested := l.requestedAction(request.Action)
+	for _, statute := range l.cachedPolicies {
+		if !requested.appliesTo(statute.policy) {
+			continue
+		}
+
+		residual, err := l.partialEval(ctx, statute, env, "subject")
+		if err != nil {
+			return nil, err
+		}
+		if residual == Constant(false) {
+			continue
+		}
+
+		constraints = append(constraints, SubjectConstraint{
+			Policy:   statute.policy,
+			Residual: residual,
+		})
+	}
+
+	return constraints, nil
+}
+
+// MatchSubjects returns the candidates that are granted action on object, in
+// the order they were given. The candidates are matched like MatchBatch does.
+func (l *Leges) MatchSubjects(object Attributes, action string, candidates []Attributes) ([]Attributes, error) {
+	return l.MatchSubjectsContext(context.Background(), Request{Object: object, Action: action}, candidates)
+}
+
+// MatchSubjectsContext is like MatchSubjects, but takes the object, the action
+// and the environment from request, whose Subject is ignored, and matches the
+// candidates with ctx.
+func (l *Leges) MatchSubjectsContext(ctx context.Context, request Request, candidates []Attributes) ([]Attributes, error) {
+	requests := make([]Request, len(candidates))
+	for i, subject := range candidates {
+		requests[i] = request
+		requests[i].Subject = subject
+	}
+
+	var subjects []Attributes
+	for i, result := range l.MatchBatchContext(ctx, requests) {
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		if result.Match {
+			subjects = append(subjects, candidates[i])
+		}
+	}
+
+	return subjects, nil
+}
@@ -0,0 +1,107 @@
+package leges_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubjectConstraints(t *testing.T) {
+	rules := mustNewLeges(t, []leges.Policy{
+		{
+			ID: "admin_can_update_pages",
+			Condition: `
+				subject.role == "admin"
+				and object.type in ["page", "adminpage"]
+			`,
+			Actions: []string{"VIEW", "UPDATE"},
+		},
+		{
+			ID:        "owner_can_update",
+			Condition: `object.owner_id == subject.id and not subject.suspended`,
+			Actions:   []string{"UPDATE"},
+		},
+		{
+			ID:        "guest_can_view_pages",
+			Condition: `subject.role == "guest" and object.type == "page"`,
+			Actions:   []string{"VIEW"},
+		},
+		{
+			ID:        "nobody_can_update_locked",
+			Condition: `object.locked`,
+			Actions:   []string{"UPDATE"},
+			Effect:    leges.EffectDeny,
+		},
+	}, nil)
+
+	object := leges.Attributes{"type": "adminpage", "owner_id": "user1", "locked": false}
+
+	t.Run("constraints of applicable policies", func(t *testing.T) {
+		constraints, err := rules.SubjectConstraints(object, "UPDATE")
+		require.NoError(t, err)
+		require.Len(t, constraints, 2)
+
+		require.Equal(t, "admin_can_update_pages", constraints[0].Policy.ID)
+		require.Equal(t, `subject.role == "admin"`, constraints[0].Residual.String())
+
+		require.Equal(t, "owner_can_update", constraints[1].Policy.ID)
+		require.Equal(t, `subject.id == "user1" and not (subject.suspended == true)`, constraints[1].Residual.String())
+	})
+
+	t.Run("unconditional constraints", func(t *testing.T) {
+		constraints, err := rules.SubjectConstraints(leges.Attributes{"type": "page", "locked": true}, "UPDATE")
+		require.NoError(t, err)
+		require.Len(t, constraints, 3)
+		require.Equal(t, `subject.id == nil and not (subject.suspended == true)`, constraints[1].Residual.String())
+		require.Equal(t, "nobody_can_update_locked", constraints[2].Policy.ID)
+		require.Equal(t, leges.Constant(true), constraints[2].Residual)
+	})
+
+	t.Run("match candidates", func(t *testing.T) {
+		admin := leges.Attributes{"id": "user2", "role": "admin", "suspended": false}
+		owner := leges.Attributes{"id": "user1", "role": "editor", "suspended": false}
+		suspendedOwner := leges.Attributes{"id": "user1", "role": "editor", "suspended": true}
+		guest := leges.Attributes{"id": "user3", "role": "guest", "suspended": false}
+
+		subjects, err := rules.MatchSubjects(object, "UPDATE", []leges.Attributes{admin, owner, suspendedOwner, guest})
+		require.NoError(t, err)
+		require.Equal(t, []leges.Attributes{admin, owner}, subjects)
+	})
+
+	t.Run("use the environment of the request", func(t *testing.T) {
+		rules := mustNewLeges(t, []leges.Policy{
+			{
+				ID:        "staff_can_update_in_the_office",
+				Condition: `subject.staff == true and env.office == true`,
+				Actions:   []string{"UPDATE"},
+			},
+		}, leges.Attributes{"office": false})
+		request := leges.Request{
+			Action:      "UPDATE",
+			Object:      object,
+			Environment: leges.Attributes{"office": true},
+		}
+
+		constraints, err := rules.SubjectConstraintsContext(context.Background(), request)
+		require.NoError(t, err)
+		require.Len(t, constraints, 1)
+		require.Equal(t, `subject.staff == true`, constraints[0].Residual.String())
+
+		staff := leges.Attributes{"id": "user2", "staff": true}
+		subjects, err := rules.MatchSubjectsContext(context.Background(), request, []leges.Attributes{staff})
+		require.NoError(t, err)
+		require.Equal(t, []leges.Attributes{staff}, subjects)
+
+		constraints, err = rules.SubjectConstraints(object, "UPDATE")
+		require.NoError(t, err)
+		require.Empty(t, constraints)
+	})
+
+	t.Run("error if object is empty", func(t *testing.T) {
+		_, err := rules.SubjectConstraints(nil, "UPDATE")
+		require.True(t, errors.Is(err, leges.ErrEmptyObjectAttrs))
+	})
+}
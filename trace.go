@@ -0,0 +1,104 @@
+package leges
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a copy of ctx carrying a correlation ID, such as
+// the ID of an HTTP request. The ID is attached to the debug events of the
+// requests matched with ctx.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationID returns the correlation ID of ctx, or an empty string if it
+// has none
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// DebugEvent is a value a condition passed to debug()
+type DebugEvent struct {
+	CorrelationID string      `json:"correlation_id,omitempty"`
+	Value         interface{} `json:"value"`
+}
+
+// Tracer receives the values conditions pass to debug(). It must be safe for
+// concurrent use.
+type Tracer interface {
+	Debug(ctx context.Context, event DebugEvent)
+}
+
+// TracerFunc is a Tracer implemented by a function
+type TracerFunc func(ctx context.Context, event DebugEvent)
+
+func (f TracerFunc) Debug(ctx context.Context, event DebugEvent) {
+	f(ctx, event)
+}
+
+// WithTracer sends the values conditions pass to debug() to tracer. Without
+// a tracer, debug() does nothing but return true.
+func WithTracer(tracer Tracer) Option {
+	return func(l *Leges) error {
+		l.tracer = tracer
+		return nil
+	}
+}
+
+// writerTracer writes debug events as JSON lines
+type writerTracer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterTracer returns a Tracer writing each debug event to w as a line of
+// JSON, such as {"correlation_id":"abc","value":42}. Values that can't be
+// encoded to JSON are formatted with %#v.
+func NewWriterTracer(w io.Writer) Tracer {
+	return &writerTracer{w: w}
+}
+
+func (t *writerTracer) Debug(ctx context.Context, event DebugEvent) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		event.Value = fmt.Sprintf("%#v", event.Value)
+		line, _ = json.Marshal(event)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.w.Write(append(line, '\n'))
+}
+
+// debugFunc returns the debug function of the conditions of a request. It
+// sends values to the tracer of l and, if collect is not nil, to collect too.
+func (l *Leges) debugFunc(ctx context.Context, collect func(value interface{})) func(value interface{}) bool {
+	if l.tracer == nil && collect == nil {
+		return debugNop
+	}
+
+	id := CorrelationID(ctx)
+	return func(value interface{}) bool {
+		if l.tracer != nil {
+			l.tracer.Debug(ctx, DebugEvent{
+				CorrelationID: id,
+				Value:         value,
+			})
+		}
+		if collect != nil {
+			collect(value)
+		}
+		return true
+	}
+}
+
+func debugNop(value interface{}) bool {
+	return true
+}
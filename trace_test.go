@@ -0,0 +1,88 @@
+package leges_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/siadat/leges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracer(t *testing.T) {
+	policies := []leges.Policy{
+		{
+			ID:        "admins_can_view",
+			Condition: `debug(subject.role) and subject.role == "admin"`,
+			Actions:   []string{"VIEW"},
+		},
+		{
+			ID:        "owners_can_view",
+			Condition: `debug(object.owner_id) and object.owner_id == subject.id`,
+			Actions:   []string{"VIEW"},
+		},
+	}
+	request := leges.Request{
+		Action:  "VIEW",
+		Subject: leges.Attributes{"id": "user1", "role": "editor"},
+		Object:  leges.Attributes{"owner_id": "user2"},
+	}
+
+	t.Run("debug values are sent to the tracer", func(t *testing.T) {
+		var (
+			mu     sync.Mutex
+			events []leges.DebugEvent
+		)
+		tracer := leges.TracerFunc(func(ctx context.Context, event leges.DebugEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, event)
+		})
+
+		rules, err := leges.NewLeges(policies, nil, leges.WithTracer(tracer))
+		require.NoError(t, err)
+
+		ctx := leges.WithCorrelationID(context.Background(), "request-1")
+		ok, _, err := rules.MatchContext(ctx, request)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		require.Equal(t, []leges.DebugEvent{
+			{CorrelationID: "request-1", Value: "editor"},
+			{CorrelationID: "request-1", Value: "user2"},
+		}, events)
+	})
+
+	t.Run("debug does nothing without a tracer", func(t *testing.T) {
+		rules := mustNewLeges(t, policies, nil)
+		ok, _, err := rules.Match(request)
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("debug values are collected in explanations", func(t *testing.T) {
+		rules := mustNewLeges(t, policies, nil)
+		explanation, err := rules.Explain(request)
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{"editor"}, explanation.Policies[0].Debug)
+		require.Equal(t, []interface{}{"user2"}, explanation.Policies[1].Debug)
+	})
+}
+
+func TestWriterTracer(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tracer := leges.NewWriterTracer(buf)
+
+	ctx := leges.WithCorrelationID(context.Background(), "request-1")
+	tracer.Debug(ctx, leges.DebugEvent{CorrelationID: "request-1", Value: map[string]interface{}{"role": "admin"}})
+	tracer.Debug(context.Background(), leges.DebugEvent{Value: func() {}})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, `{"correlation_id":"request-1","value":{"role":"admin"}}`, lines[0])
+	// values that can't be encoded as JSON are formatted instead
+	require.True(t, strings.HasPrefix(lines[1], `{"value":"(func())`), lines[1])
+	require.Equal(t, "request-1", leges.CorrelationID(ctx))
+}